@@ -1,22 +1,249 @@
 package intervals
 
-// Interval represents a half-open interval [Start, End).
-type Interval struct {
-	Start float64
-	End   float64
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// ErrInvalidInterval is reported for an interval whose Start is not before
+// its End.
+var ErrInvalidInterval = errors.New("intervals: invalid interval")
+
+// Span represents a half-open interval [Start, End) over any ordered type.
+// Use Span[int64] for integer ranges, such as nanosecond timestamps, that
+// would lose precision as float64.
+type Span[T cmp.Ordered] struct {
+	Start T
+	End   T
+}
+
+// Interval represents a half-open interval [Start, End) of float64 values.
+// It is Span[float64], so every function in this package accepts it.
+type Interval = Span[float64]
+
+// Valid reports whether i is non-empty, that is Start < End.
+func (i Span[T]) Valid() bool {
+	return i.Start < i.End
+}
+
+// Contains reports whether x lies in i. Start is inside and End is not, as
+// with HasOverlap.
+func (i Span[T]) Contains(x T) bool {
+	return i.Start <= x && x < i.End
+}
+
+// ContainsInterval reports whether every point of other lies in i. An
+// invalid other contains no points and so is never reported as contained.
+func (i Span[T]) ContainsInterval(other Span[T]) bool {
+	return other.Valid() && i.Start <= other.Start && other.End <= i.End
 }
 
 // HasOverlap returns true when the two intervals share any interior points.
-//
-// BUG: the current implementation mishandles touching boundaries.
-func HasOverlap(a, b Interval) bool {
-	if a.Start >= a.End || b.Start >= b.End {
+// Intervals that merely touch, such as [0, 1) and [1, 2), do not overlap. It
+// panics if either interval is invalid; see TryOverlap.
+func HasOverlap[T cmp.Ordered](a, b Span[T]) bool {
+	if !a.Valid() || !b.Valid() {
+		panic("invalid interval")
+	}
+	return overlaps(a, b)
+}
+
+// TryOverlap is HasOverlap for untrusted input: it returns an error wrapping
+// ErrInvalidInterval instead of panicking.
+func TryOverlap[T cmp.Ordered](a, b Span[T]) (bool, error) {
+	for _, i := range []Span[T]{a, b} {
+		if !i.Valid() {
+			return false, fmt.Errorf("%w: %v", ErrInvalidInterval, i)
+		}
+	}
+	return overlaps(a, b), nil
+}
+
+// Intersection returns the region [max(starts), min(ends)) shared by a and b,
+// and false, with a zero Interval, when they do not overlap as defined by
+// HasOverlap. It panics if either interval is invalid.
+func Intersection[T cmp.Ordered](a, b Span[T]) (Span[T], bool) {
+	if !HasOverlap(a, b) {
+		return Span[T]{}, false
+	}
+	return Span[T]{Start: max(a.Start, b.Start), End: min(a.End, b.End)}, true
+}
+
+// Clamp returns the part of i that lies within bounds, and false when there
+// is none. It is Intersection(i, bounds), so an interval that only touches
+// bounds at either end yields false, and it panics if either is invalid.
+func (i Span[T]) Clamp(bounds Span[T]) (Span[T], bool) {
+	return Intersection(i, bounds)
+}
+
+// Adjacent reports whether a and b touch without overlapping, that is one
+// ends exactly where the other starts, as [0, 1) and [1, 2) do. It panics if
+// either interval is invalid, like HasOverlap.
+func Adjacent[T cmp.Ordered](a, b Span[T]) bool {
+	if !a.Valid() || !b.Valid() {
+		panic("invalid interval")
+	}
+	return a.End == b.Start || b.End == a.Start
+}
+
+// Merge coalesces intervals into the smallest set of disjoint intervals
+// covering the same points, sorted by Start. Intervals that touch, such as
+// [0, 1) and [1, 2), are merged too, since together they cover [0, 2) without
+// a gap. The input slice is not modified, and an empty input yields nil. It
+// panics if any interval is invalid, like HasOverlap.
+func Merge[T cmp.Ordered](intervals []Span[T]) []Span[T] {
+	if len(intervals) == 0 {
+		return nil
+	}
+	sorted := make([]Span[T], len(intervals))
+	copy(sorted, intervals)
+	for _, i := range sorted {
+		if !i.Valid() {
+			panic("invalid interval")
+		}
+	}
+	SortByStart(sorted)
+
+	merged := sorted[:1]
+	for _, i := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if i.Start <= last.End {
+			last.End = max(last.End, i.End)
+			continue
+		}
+		merged = append(merged, i)
+	}
+	return merged
+}
+
+// SortByStart sorts intervals in place by Start, and by End among equal
+// starts, and returns the slice.
+func SortByStart[T cmp.Ordered](intervals []Span[T]) []Span[T] {
+	sort.Slice(intervals, func(i, j int) bool {
+		a, b := intervals[i], intervals[j]
+		if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		return a.End < b.End
+	})
+	return intervals
+}
+
+// SortByStartValid is SortByStart for untrusted input: if any interval is
+// invalid it returns an error wrapping ErrInvalidInterval and leaves the
+// slice unsorted.
+func SortByStartValid[T cmp.Ordered](intervals []Span[T]) ([]Span[T], error) {
+	for n, i := range intervals {
+		if !i.Valid() {
+			return intervals, fmt.Errorf("%w: %v at index %d", ErrInvalidInterval, i, n)
+		}
+	}
+	return SortByStart(intervals), nil
+}
+
+// Gaps returns the parts of within that none of intervals cover, in order:
+// the complement of their union, clipped to within. Intervals reaching
+// outside within are clipped to it, and with no intervals the whole of within
+// is one gap. It panics if within or any interval is invalid, like Merge.
+func Gaps[T cmp.Ordered](intervals []Span[T], within Span[T]) []Span[T] {
+	if !within.Valid() {
 		panic("invalid interval")
 	}
+	var gaps []Span[T]
+	next := within.Start // the first point not yet known to be covered
+	for _, i := range Merge(intervals) {
+		if i.Start >= within.End {
+			break
+		}
+		if i.Start > next {
+			gaps = append(gaps, Span[T]{Start: next, End: i.Start})
+		}
+		next = max(next, i.End)
+	}
+	if next < within.End {
+		gaps = append(gaps, Span[T]{Start: next, End: within.End})
+	}
+	return gaps
+}
+
+// FindOverlaps returns the index pairs of intervals that overlap as defined
+// by HasOverlap, such as double bookings of a room; touching intervals are
+// not reported. Each pair is given once with the smaller index first, and
+// the pairs are sorted. A sweep in order of Start compares each interval only
+// with those still open when it starts, so the cost is O(n log n + k) for k
+// pairs unless many intervals are open at once. The input is not modified,
+// and it panics if any interval is invalid, like Merge.
+func FindOverlaps[T cmp.Ordered](intervals []Span[T]) [][2]int {
+	order := make([]int, len(intervals))
+	for n, i := range intervals {
+		if !i.Valid() {
+			panic("invalid interval")
+		}
+		order[n] = n
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return cmp.Compare(intervals[a].Start, intervals[b].Start)
+	})
+	var pairs [][2]int
+	var open []int // intervals started so far that may still overlap later ones
+	for _, n := range order {
+		start := intervals[n].Start
+		open = slices.DeleteFunc(open, func(m int) bool { return intervals[m].End <= start })
+		for _, m := range open {
+			pairs = append(pairs, [2]int{min(m, n), max(m, n)})
+		}
+		open = append(open, n)
+	}
+	slices.SortFunc(pairs, func(a, b [2]int) int {
+		if c := cmp.Compare(a[0], b[0]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a[1], b[1])
+	})
+	return pairs
+}
+
+// Split cuts i at each of points that lies strictly inside it, returning the
+// consecutive pieces in order; together they cover exactly i. Points may be
+// in any order and are not modified. Points outside i or on its bounds are
+// ignored, as are duplicates, so no piece is empty, and with no such points
+// the result is i alone. It panics if i is invalid.
+func Split[T cmp.Ordered](i Span[T], points []T) []Span[T] {
+	if !i.Valid() {
+		panic("invalid interval")
+	}
+	cuts := make([]T, 0, len(points))
+	for _, p := range points {
+		if i.Start < p && p < i.End {
+			cuts = append(cuts, p)
+		}
+	}
+	slices.Sort(cuts)
+	cuts = slices.Compact(cuts)
+	pieces := make([]Span[T], 0, len(cuts)+1)
+	for _, p := range cuts {
+		pieces = append(pieces, Span[T]{Start: i.Start, End: p})
+		i.Start = p
+	}
+	return append(pieces, i)
+}
 
-	if a.Start > b.Start {
-		a, b = b, a
+// TotalCoverage returns the total length of the union of intervals, counting
+// regions covered by several intervals once. The input may be in any order
+// and is not modified. An empty input covers 0, and like Merge it panics if
+// any interval is invalid, including zero-length ones.
+func TotalCoverage(intervals []Interval) float64 {
+	var total float64
+	for _, i := range Merge(intervals) {
+		total += i.End - i.Start
 	}
+	return total
+}
 
-	return a.End >= b.Start
+// overlaps reports whether two valid intervals share an interior point.
+func overlaps[T cmp.Ordered](a, b Span[T]) bool {
+	return a.Start < b.End && b.Start < a.End
 }
@@ -1,6 +1,11 @@
 package intervals
 
-import "testing"
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+)
 
 func TestOverlappingIntervals(t *testing.T) {
 	cases := []struct {
@@ -65,3 +70,428 @@ func TestLargeNumbersPrecision(t *testing.T) {
 		t.Fatal("boundary contact should not count as overlap")
 	}
 }
+
+func TestTryOverlapReportsInvalidIntervals(t *testing.T) {
+	if _, err := TryOverlap(Interval{5, 5}, Interval{1, 2}); !errors.Is(err, ErrInvalidInterval) {
+		t.Fatalf("expected ErrInvalidInterval, got %v", err)
+	}
+	if _, err := TryOverlap(Interval{0, 1}, Interval{1, 0}); !errors.Is(err, ErrInvalidInterval) {
+		t.Fatalf("expected ErrInvalidInterval, got %v", err)
+	}
+
+	overlap, err := TryOverlap(Interval{0, 2}, Interval{1, 3})
+	if err != nil || !overlap {
+		t.Fatalf("expected overlap without error, got %v %v", overlap, err)
+	}
+	overlap, err = TryOverlap(Interval{0, 1}, Interval{1, 2})
+	if err != nil || overlap {
+		t.Fatalf("expected no overlap without error, got %v %v", overlap, err)
+	}
+
+	if (Interval{1, 1}).Valid() || !(Interval{1, 2}).Valid() {
+		t.Fatal("Valid should require Start < End")
+	}
+}
+
+func TestIntersection(t *testing.T) {
+	cases := []struct {
+		a, b Interval
+		want Interval
+		ok   bool
+	}{
+		{Interval{0, 2}, Interval{1, 3}, Interval{1, 2}, true},
+		{Interval{1.5, 4.5}, Interval{2, 2.5}, Interval{2, 2.5}, true},
+		{Interval{0, 1e12}, Interval{1e12 - 1, 2e12}, Interval{1e12 - 1, 1e12}, true},
+		{Interval{0, 1e12}, Interval{1e12, 2e12}, Interval{}, false},
+		{Interval{10, 20}, Interval{20, 21}, Interval{}, false},
+		{Interval{-3, -1}, Interval{0, 5}, Interval{}, false},
+	}
+
+	for _, tc := range cases {
+		for _, pair := range [][2]Interval{{tc.a, tc.b}, {tc.b, tc.a}} {
+			got, ok := Intersection(pair[0], pair[1])
+			if got != tc.want || ok != tc.ok {
+				t.Fatalf("Intersection(%v, %v) = %v, %v; want %v, %v", pair[0], pair[1], got, ok, tc.want, tc.ok)
+			}
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+	bounds := Interval{0, 10}
+	cases := []struct {
+		i    Interval
+		want Interval
+		ok   bool
+	}{
+		{Interval{2, 5}, Interval{2, 5}, true},
+		{Interval{-3, 4}, Interval{0, 4}, true},
+		{Interval{8, 12}, Interval{8, 10}, true},
+		{Interval{-1, 11}, Interval{0, 10}, true},
+		{Interval{10, 12}, Interval{}, false},
+		{Interval{-4, -2}, Interval{}, false},
+	}
+	for _, c := range cases {
+		got, ok := c.i.Clamp(bounds)
+		if got != c.want || ok != c.ok {
+			t.Errorf("%v.Clamp(%v) = %v, %v; want %v, %v", c.i, bounds, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestAdjacent(t *testing.T) {
+	a, b := Interval{0, 1}, Interval{1, 2}
+	if !Adjacent(a, b) || !Adjacent(b, a) {
+		t.Fatal("expected [0, 1) and [1, 2) to be adjacent in either order")
+	}
+	if Adjacent(Interval{0, 2}, Interval{1, 3}) {
+		t.Fatal("overlapping intervals should not be adjacent")
+	}
+	if Adjacent(Interval{0, 1}, Interval{2, 3}) {
+		t.Fatal("separated intervals should not be adjacent")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	input := []Interval{{5, 7}, {0, 1}, {1, 2}, {6, 9}, {3, 4}, {3.5, 3.75}}
+	want := []Interval{{0, 2}, {3, 4}, {5, 9}}
+
+	got := Merge(input)
+	if len(got) != len(want) {
+		t.Fatalf("Merge(%v) = %v; want %v", input, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Merge(%v) = %v; want %v", input, got, want)
+		}
+	}
+	if input[0] != (Interval{5, 7}) {
+		t.Fatal("Merge should not reorder its input")
+	}
+
+	if got := Merge([]Interval(nil)); got != nil {
+		t.Fatalf("expected nil for empty input, got %v", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for an invalid interval")
+		}
+	}()
+	Merge([]Interval{{0, 1}, {2, 2}})
+}
+
+func TestSortByStart(t *testing.T) {
+	input := []Interval{{3, 4}, {1, 5}, {1, 2}, {0, 9}, {1, 3}}
+	got := SortByStart(input)
+	want := []Interval{{0, 9}, {1, 2}, {1, 3}, {1, 5}, {3, 4}}
+	for i := range want {
+		if got[i] != want[i] || input[i] != want[i] {
+			t.Fatalf("SortByStart = %v; want %v sorted in place", got, want)
+		}
+	}
+
+	bad := []Interval{{2, 3}, {1, 1}, {0, 1}}
+	if _, err := SortByStartValid(bad); !errors.Is(err, ErrInvalidInterval) {
+		t.Fatalf("expected ErrInvalidInterval, got %v", err)
+	}
+	if bad[0] != (Interval{2, 3}) {
+		t.Fatal("invalid input should be left unsorted")
+	}
+	if _, err := SortByStartValid(want); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestTotalCoverage(t *testing.T) {
+	// [0, 4) and [2, 6) overlap to cover [0, 6); [8, 9) and [9, 10) touch.
+	input := []Interval{{8, 9}, {2, 6}, {20, 20.5}, {0, 4}, {9, 10}}
+	if got := TotalCoverage(input); got != 8.5 {
+		t.Fatalf("TotalCoverage(%v) = %v; want 8.5", input, got)
+	}
+	if got := TotalCoverage(nil); got != 0 {
+		t.Fatalf("TotalCoverage(nil) = %v; want 0", got)
+	}
+}
+
+func TestGaps(t *testing.T) {
+	within := Interval{0, 10}
+	cases := []struct {
+		name      string
+		intervals []Interval
+		want      []Interval
+	}{
+		{"none", nil, []Interval{{0, 10}}},
+		{"start and end", []Interval{{6, 8}, {2, 4}}, []Interval{{0, 2}, {4, 6}, {8, 10}}},
+		{"clipped", []Interval{{-5, 3}, {7, 15}}, []Interval{{3, 7}}},
+		{"covered", []Interval{{-1, 5}, {5, 11}}, nil},
+		{"outside", []Interval{{-3, -1}, {12, 13}}, []Interval{{0, 10}}},
+		{"touching window", []Interval{{-2, 0}, {10, 12}}, []Interval{{0, 10}}},
+	}
+	for _, c := range cases {
+		got := Gaps(c.intervals, within)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: Gaps(%v) = %v; want %v", c.name, c.intervals, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: Gaps(%v) = %v; want %v", c.name, c.intervals, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	i := Interval{0, 10}
+	assertIntervals(t, Split(i, []float64{7, 3}), []Interval{{0, 3}, {3, 7}, {7, 10}})
+	// Bounds, outside points and duplicates make no empty pieces.
+	points := []float64{5, 0, 10, -1, 12, 5}
+	assertIntervals(t, Split(i, points), []Interval{{0, 5}, {5, 10}})
+	if points[0] != 5 || points[1] != 0 {
+		t.Fatalf("Split reordered its points: %v", points)
+	}
+	assertIntervals(t, Split(i, nil), []Interval{{0, 10}})
+}
+
+func TestFindOverlaps(t *testing.T) {
+	rooms := []Interval{
+		{9, 11},
+		{10, 12},
+		{10, 10.5},
+		{12, 13}, // touches 1 but lies within 5
+		{14, 16},
+		{8, 15}, // overlaps every other room
+	}
+	want := [][2]int{{0, 1}, {0, 2}, {0, 5}, {1, 2}, {1, 5}, {2, 5}, {3, 5}, {4, 5}}
+	got := FindOverlaps(rooms)
+	if len(got) != len(want) {
+		t.Fatalf("FindOverlaps = %v; want %v", got, want)
+	}
+	for n := range want {
+		if got[n] != want[n] {
+			t.Fatalf("FindOverlaps = %v; want %v", got, want)
+		}
+	}
+	// Brute force agrees on random input.
+	r := rand.New(rand.NewSource(1))
+	random := make([]Interval, 40)
+	for n := range random {
+		start := float64(r.Intn(100))
+		random[n] = Interval{start, start + float64(1+r.Intn(10))}
+	}
+	var brute [][2]int
+	for a := range random {
+		for b := a + 1; b < len(random); b++ {
+			if HasOverlap(random[a], random[b]) {
+				brute = append(brute, [2]int{a, b})
+			}
+		}
+	}
+	got = FindOverlaps(random)
+	if len(got) != len(brute) {
+		t.Fatalf("FindOverlaps found %d pairs; brute force %d", len(got), len(brute))
+	}
+	for n := range brute {
+		if got[n] != brute[n] {
+			t.Fatalf("pair %d: got %v; want %v", n, got[n], brute[n])
+		}
+	}
+	if got := FindOverlaps([]Interval{{0, 1}, {1, 2}}); len(got) != 0 {
+		t.Fatalf("touching intervals overlap: %v", got)
+	}
+}
+
+func TestInt64SpansKeepPrecision(t *testing.T) {
+	// 1<<60 + 1 is not representable as a float64, which rounds it to 1<<60.
+	const big int64 = 1 << 60
+	a := Span[int64]{0, big + 1}
+	if !HasOverlap(a, Span[int64]{big, 2 * big}) {
+		t.Fatal("expected overlap at the last nanosecond")
+	}
+	if HasOverlap(a, Span[int64]{big + 1, 2 * big}) {
+		t.Fatal("boundary contact should not count as overlap")
+	}
+	if got, ok := Intersection(a, Span[int64]{big, 2 * big}); !ok || got != (Span[int64]{big, big + 1}) {
+		t.Fatalf("unexpected intersection %v, %v", got, ok)
+	}
+	merged := Merge([]Span[int64]{{big + 1, big + 2}, {0, big + 1}})
+	if len(merged) != 1 || merged[0] != (Span[int64]{0, big + 2}) {
+		t.Fatalf("unexpected merge %v", merged)
+	}
+}
+
+func TestContains(t *testing.T) {
+	i := Interval{1, 3}
+	if !i.Contains(1) {
+		t.Fatal("Start should be inside")
+	}
+	if !i.Contains(2.5) {
+		t.Fatal("expected 2.5 inside")
+	}
+	if i.Contains(3) {
+		t.Fatal("End should be outside")
+	}
+	if i.Contains(0.5) {
+		t.Fatal("expected 0.5 outside")
+	}
+}
+
+func TestContainsInterval(t *testing.T) {
+	outer := Interval{0, 10}
+	cases := []struct {
+		inner Interval
+		want  bool
+	}{
+		{Interval{0, 10}, true},
+		{Interval{2, 5}, true},
+		{Interval{5, 11}, false},
+		{Interval{-1, 5}, false},
+		{Interval{10, 12}, false},
+		{Interval{4, 4}, false},
+	}
+	for _, c := range cases {
+		if got := outer.ContainsInterval(c.inner); got != c.want {
+			t.Errorf("%v.ContainsInterval(%v) = %v; want %v", outer, c.inner, got, c.want)
+		}
+	}
+}
+
+func TestIntervalTreeMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// Whole-number endpoints make boundary contact common.
+	random := func() Interval {
+		start := float64(rng.Intn(100))
+		return Interval{start, start + float64(1+rng.Intn(20))}
+	}
+	sorted := func(s []Interval) []Interval {
+		sort.Slice(s, func(i, j int) bool {
+			if s[i].Start != s[j].Start {
+				return s[i].Start < s[j].Start
+			}
+			return s[i].End < s[j].End
+		})
+		return s
+	}
+	equal := func(a, b []Interval) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	var tree IntervalTree
+	var all []Interval
+	for n := 0; n < 500; n++ {
+		i := random()
+		tree.Insert(i)
+		all = append(all, i)
+	}
+	if tree.Len() != len(all) {
+		t.Fatalf("Len() = %d; want %d", tree.Len(), len(all))
+	}
+
+	for q := 0; q < 200; q++ {
+		p := float64(rng.Intn(130)) - 5
+		if q%2 == 1 {
+			p += 0.5
+		}
+		var want []Interval
+		for _, i := range all {
+			if i.Contains(p) {
+				want = append(want, i)
+			}
+		}
+		if got := sorted(tree.Stab(p)); !equal(got, sorted(want)) {
+			t.Fatalf("Stab(%v) = %v; want %v", p, got, want)
+		}
+
+		query := random()
+		want = want[:0]
+		for _, i := range all {
+			if HasOverlap(i, query) {
+				want = append(want, i)
+			}
+		}
+		if got := sorted(tree.Overlapping(query)); !equal(got, sorted(want)) {
+			t.Fatalf("Overlapping(%v) = %v; want %v", query, got, want)
+		}
+	}
+}
+
+func TestIntervalTreeHalfOpen(t *testing.T) {
+	var tree IntervalTree
+	tree.Insert(Interval{0, 1})
+	tree.Insert(Interval{1, 2})
+	if got := tree.Stab(1); len(got) != 1 || got[0] != (Interval{1, 2}) {
+		t.Fatalf("Stab(1) = %v; want only [1, 2)", got)
+	}
+	if got := tree.Overlapping(Interval{2, 3}); len(got) != 0 {
+		t.Fatalf("touching query should match nothing, got %v", got)
+	}
+	if got := tree.Stab(5); got != nil {
+		t.Fatalf("Stab(5) = %v; want nil", got)
+	}
+}
+
+func TestIntervalSetMergesOnAdd(t *testing.T) {
+	var set IntervalSet
+	set.Add(Interval{5, 7})
+	set.Add(Interval{0, 2})
+	set.Add(Interval{10, 12})
+	set.Add(Interval{1, 5}) // overlaps [0, 2) and touches [5, 7)
+	assertIntervals(t, set.Intervals(), []Interval{{0, 7}, {10, 12}})
+
+	set.Add(Interval{-1, 20})
+	assertIntervals(t, set.Intervals(), []Interval{{-1, 20}})
+}
+
+func TestIntervalSetRemoveSplits(t *testing.T) {
+	var set IntervalSet
+	set.Add(Interval{0, 10})
+	set.Remove(Interval{3, 6})
+	assertIntervals(t, set.Intervals(), []Interval{{0, 3}, {6, 10}})
+
+	if !set.Contains(0) || !set.Contains(2.9) || set.Contains(3) || set.Contains(5.9) || !set.Contains(6) || set.Contains(10) {
+		t.Fatalf("unexpected membership for %v", set.Intervals())
+	}
+
+	set.Remove(Interval{10, 11}) // only touches
+	set.Remove(Interval{2, 8})
+	assertIntervals(t, set.Intervals(), []Interval{{0, 2}, {8, 10}})
+	set.Remove(Interval{-5, 50})
+	if set.Len() != 0 {
+		t.Fatalf("expected an empty set, got %v", set.Intervals())
+	}
+}
+
+func TestIntervalSetMatchesMerge(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	var set IntervalSet
+	var added []Interval
+	for n := 0; n < 200; n++ {
+		start := float64(rng.Intn(500))
+		i := Interval{start, start + float64(1+rng.Intn(10))}
+		set.Add(i)
+		added = append(added, i)
+	}
+	assertIntervals(t, set.Intervals(), Merge(added))
+}
+
+func assertIntervals(t *testing.T, got, want []Interval) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v; want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package intervals
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+)
+
+// SpanSet is a set of points kept as disjoint, non-touching spans sorted by
+// Start, the form Merge produces. Adding a span merges it with any it
+// overlaps or touches, and removing one cuts it out of those it overlaps,
+// splitting a span in two if need be. Lookups take O(log n) time and updates
+// O(n) at worst to shift the spans after the change. The zero value is an
+// empty set. A SpanSet is not safe for concurrent use.
+type SpanSet[T cmp.Ordered] struct {
+	spans []Span[T]
+}
+
+// IntervalSet is a SpanSet of float64 intervals.
+type IntervalSet = SpanSet[float64]
+
+// Add adds the points of s to the set. It panics if s is invalid.
+func (set *SpanSet[T]) Add(s Span[T]) {
+	if !s.Valid() {
+		panic("invalid interval")
+	}
+	// Spans from lo to hi overlap or touch s.
+	lo := sort.Search(len(set.spans), func(i int) bool { return set.spans[i].End >= s.Start })
+	hi := sort.Search(len(set.spans), func(i int) bool { return set.spans[i].Start > s.End })
+	if lo < hi {
+		s.Start = min(s.Start, set.spans[lo].Start)
+		s.End = max(s.End, set.spans[hi-1].End)
+	}
+	set.spans = slices.Replace(set.spans, lo, hi, s)
+}
+
+// Remove removes the points of s from the set. It panics if s is invalid.
+func (set *SpanSet[T]) Remove(s Span[T]) {
+	if !s.Valid() {
+		panic("invalid interval")
+	}
+	// Spans from lo to hi overlap s.
+	lo := sort.Search(len(set.spans), func(i int) bool { return set.spans[i].End > s.Start })
+	hi := sort.Search(len(set.spans), func(i int) bool { return set.spans[i].Start >= s.End })
+	if lo >= hi {
+		return
+	}
+	var kept []Span[T]
+	if first := set.spans[lo]; first.Start < s.Start {
+		kept = append(kept, Span[T]{Start: first.Start, End: s.Start})
+	}
+	if last := set.spans[hi-1]; last.End > s.End {
+		kept = append(kept, Span[T]{Start: s.End, End: last.End})
+	}
+	set.spans = slices.Replace(set.spans, lo, hi, kept...)
+}
+
+// Contains reports whether point is in the set.
+func (set *SpanSet[T]) Contains(point T) bool {
+	i := sort.Search(len(set.spans), func(i int) bool { return set.spans[i].End > point })
+	return i < len(set.spans) && set.spans[i].Contains(point)
+}
+
+// Len returns the number of disjoint spans in the set.
+func (set *SpanSet[T]) Len() int { return len(set.spans) }
+
+// Intervals returns a copy of the set's spans, sorted by Start. They are
+// valid, disjoint and do not touch.
+func (set *SpanSet[T]) Intervals() []Span[T] {
+	return slices.Clone(set.spans)
+}
@@ -0,0 +1,140 @@
+package intervals
+
+import "cmp"
+
+// SpanTree holds a set of spans for repeated stabbing and overlap queries.
+// It is an AVL tree ordered by Start in which every node also records the
+// largest End beneath it, so a query skips any subtree that ends before the
+// region asked about: inserts take O(log n) time and queries O(log n + k) for
+// k results. The zero value is an empty tree. A SpanTree is not safe for
+// concurrent use.
+type SpanTree[T cmp.Ordered] struct {
+	root *spanNode[T]
+	n    int
+}
+
+// IntervalTree is a SpanTree of float64 intervals.
+type IntervalTree = SpanTree[float64]
+
+type spanNode[T cmp.Ordered] struct {
+	span        Span[T]
+	maxEnd      T // the largest End in this subtree
+	height      int
+	left, right *spanNode[T]
+}
+
+// Len returns the number of spans in the tree.
+func (t *SpanTree[T]) Len() int { return t.n }
+
+// Insert adds s to the tree; duplicates are kept. It panics if s is invalid,
+// like HasOverlap.
+func (t *SpanTree[T]) Insert(s Span[T]) {
+	if !s.Valid() {
+		panic("invalid interval")
+	}
+	t.root = t.root.insert(s)
+	t.n++
+}
+
+// Stab returns the spans containing point, that is those with
+// Start <= point < End, sorted by Start.
+func (t *SpanTree[T]) Stab(point T) []Span[T] {
+	var out []Span[T]
+	t.root.search(point, point, true, &out)
+	return out
+}
+
+// Overlapping returns the spans that overlap query as defined by HasOverlap,
+// sorted by Start. Spans that merely touch query are not included. It panics
+// if query is invalid.
+func (t *SpanTree[T]) Overlapping(query Span[T]) []Span[T] {
+	if !query.Valid() {
+		panic("invalid interval")
+	}
+	var out []Span[T]
+	t.root.search(query.Start, query.End, false, &out)
+	return out
+}
+
+// search appends, in order, the spans under n with End > lo and Start < hi,
+// or Start <= hi if inclusive.
+func (n *spanNode[T]) search(lo, hi T, inclusive bool, out *[]Span[T]) {
+	if n == nil || n.maxEnd <= lo {
+		return
+	}
+	n.left.search(lo, hi, inclusive, out)
+	if n.span.Start > hi || !inclusive && n.span.Start == hi {
+		// Everything to the right starts at least as late.
+		return
+	}
+	if n.span.End > lo {
+		*out = append(*out, n.span)
+	}
+	n.right.search(lo, hi, inclusive, out)
+}
+
+func (n *spanNode[T]) insert(s Span[T]) *spanNode[T] {
+	if n == nil {
+		return &spanNode[T]{span: s, maxEnd: s.End, height: 1}
+	}
+	if s.Start < n.span.Start {
+		n.left = n.left.insert(s)
+	} else {
+		n.right = n.right.insert(s)
+	}
+	return n.rebalance()
+}
+
+func (n *spanNode[T]) heightOf() int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+// update recomputes n's height and maxEnd from its children.
+func (n *spanNode[T]) update() {
+	n.height = 1 + max(n.left.heightOf(), n.right.heightOf())
+	n.maxEnd = n.span.End
+	if n.left != nil {
+		n.maxEnd = max(n.maxEnd, n.left.maxEnd)
+	}
+	if n.right != nil {
+		n.maxEnd = max(n.maxEnd, n.right.maxEnd)
+	}
+}
+
+// rebalance restores the AVL invariant at n after an insert below it and
+// returns the subtree's new root.
+func (n *spanNode[T]) rebalance() *spanNode[T] {
+	n.update()
+	switch balance := n.left.heightOf() - n.right.heightOf(); {
+	case balance > 1:
+		if n.left.left.heightOf() < n.left.right.heightOf() {
+			n.left = n.left.rotateLeft()
+		}
+		return n.rotateRight()
+	case balance < -1:
+		if n.right.right.heightOf() < n.right.left.heightOf() {
+			n.right = n.right.rotateRight()
+		}
+		return n.rotateLeft()
+	}
+	return n
+}
+
+func (n *spanNode[T]) rotateLeft() *spanNode[T] {
+	r := n.right
+	n.right, r.left = r.left, n
+	n.update()
+	r.update()
+	return r
+}
+
+func (n *spanNode[T]) rotateRight() *spanNode[T] {
+	l := n.left
+	n.left, l.right = l.right, n
+	n.update()
+	l.update()
+	return l
+}
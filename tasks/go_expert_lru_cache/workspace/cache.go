@@ -1,100 +1,682 @@
-   if c.head == e {
-       return
-   }
-   c.remove(e)
-   c.addToFront(e)
-   }
-   return &Cache{
-       capacity: capacity,
-       items:    make(map[string]*entry, capacity),
-   }
-}
-// Get fetches a value, marking the key as recently used.
-func (c *Cache) Get(key string) (any, bool) {
-   c.mu.Lock()
-   defer c.mu.Unlock()
-   e, ok := c.items[key]
-   if !ok {
-       return nil, false
-   }
-   c.moveToFront(e)
-   return e.value, true
-}
-// Set inserts or updates a value, evicting the least recently used entry.
-func (c *Cache) Set(key string, value any) {
-   c.mu.Lock()
-   defer c.mu.Unlock()
-   if e, ok := c.items[key]; ok {
-       e.value = value
-       c.moveToFront(e)
-       return
-   }
-   e := &entry{key: key, value: value}
-   c.items[key] = e
-   c.addToFront(e)
-   c.size++
-   if c.size > c.capacity {
-       // evict tail
-       old := c.tail
-       c.remove(old)
-       delete(c.items, old.key)
-       c.size--
-   }
-}
-// Len returns the current number of entries.
-func (c *Cache) Len() int {
-   c.mu.Lock()
-   defer c.mu.Unlock()
-   return c.size
-}
-
-// entry is a node in a doubly linked list for LRU.
-type entry struct {
-   key   string
-   value any
-   prev  *entry
-   next  *entry
-}
-
-// addToFront adds e as most recently used.
-func (c *Cache) addToFront(e *entry) {
-   if c.head == nil {
-       c.head, c.tail = e, e
-       return
-   }
-   e.next = c.head
-   c.head.prev = e
-   c.head = e
+package cache
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TypedCache is a goroutine-safe LRU cache holding at most a fixed number of
+// entries with keys of type K and values of type V. Entries sit on a doubly
+// linked list ordered from most to least recently used, so lookups, promotion
+// and eviction all run in constant time.
+// SetEvictionPolicy swaps LRU eviction for another strategy.
+type TypedCache[K comparable, V any] struct {
+	mu       sync.RWMutex
+	clock    Clock
+	shared   bool              // Get takes a read lock; see WithConcurrentReads
+	policy   EvictionPolicy[K] // nil for the built-in LRU order
+	capacity int
+	size     int
+	maxCost  int64 // zero if only the entry count is bounded
+	cost     int64
+	items    map[K]*entry[K, V]
+	head     *entry[K, V] // most recently used
+	tail     *entry[K, V] // least recently used
+
+	stats   counters
+	onEvict func(K, V)
+	evicted []*entry[K, V] // dropped under mu, reported once it is released
+	loads   map[K]*load[V] // GetOrCompute calls in flight
+
+	negativeTTL time.Duration
+	misses      map[K]time.Time // keys a loader reported missing, until when
+
+	stop      chan struct{} // closed by Close to stop the sweeper
+	stopped   chan struct{} // closed when the sweeper has returned
+	closeOnce sync.Once
+}
+
+// Cache is the TypedCache of string keys and untyped values that New
+// returns.
+type Cache = TypedCache[string, any]
+
+// Clock tells the time for a cache's expiry checks. The default uses the time
+// package; tests can supply a fake.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the default Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// Option configures a cache created by New or NewTyped.
+type Option func(*options)
+
+type options struct {
+	clock         Clock
+	sweepInterval time.Duration
+	shared        bool
+	maxCost       int64
+	negativeTTL   time.Duration
+}
+
+// WithClock makes the cache read time from c when setting and checking TTLs.
+func WithClock(c Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// WithSweeper starts a background goroutine that removes expired entries
+// every interval, so they stop taking up capacity without waiting to be
+// looked up. Call Close to stop it. It panics if interval is not positive.
+func WithSweeper(interval time.Duration) Option {
+	if interval <= 0 {
+		panic("cache: sweep interval must be positive")
+	}
+	return func(o *options) { o.sweepInterval = interval }
+}
+
+// WithConcurrentReads lets Get calls run in parallel under a read lock. A Get
+// then only marks its entry as referenced instead of moving it to the front,
+// and eviction gives a referenced entry at the tail a second chance, moving it
+// to the front and clearing the mark, before evicting the first unreferenced
+// one. This approximates LRU: an entry read since it last reached the tail
+// survives, but entries read in between are not ordered by how recently they
+// were read, and Range sees that coarser order. Writes still take the lock
+// exclusively.
+func WithConcurrentReads() Option {
+	return func(o *options) { o.shared = true }
+}
+
+// WithMaxCost bounds the total cost of the entries as well as their number:
+// inserting evicts entries until both fit. Entries stored with SetWithCost
+// carry the given cost and all others a cost of 1. It panics if maxCost is
+// not positive.
+func WithMaxCost(maxCost int64) Option {
+	if maxCost <= 0 {
+		panic("cache: max cost must be positive")
+	}
+	return func(o *options) { o.maxCost = maxCost }
+}
+
+// New creates a cache of string keys and untyped values holding at most
+// capacity entries. It panics if capacity is not positive. Use NewTyped to
+// pick the key and value types.
+func New(capacity int, opts ...Option) *Cache {
+	return NewTyped[string, any](capacity, opts...)
+}
+
+// NewWithSweeper is New with WithSweeper(interval). Call Close once the cache
+// is no longer needed to stop the sweeper.
+func NewWithSweeper(capacity int, interval time.Duration, opts ...Option) *Cache {
+	return New(capacity, append(opts, WithSweeper(interval))...)
+}
+
+// NewConcurrent is New with WithConcurrentReads, for read-heavy workloads
+// that can accept approximate LRU eviction.
+func NewConcurrent(capacity int, opts ...Option) *Cache {
+	return New(capacity, append(opts, WithConcurrentReads())...)
+}
+
+// NewWithMaxCost creates a string/any cache bounded only by the total cost
+// of its entries; see WithMaxCost and SetWithCost.
+func NewWithMaxCost(maxCost int64, opts ...Option) *Cache {
+	return New(math.MaxInt, append(opts, WithMaxCost(maxCost))...)
+}
+
+// NewTyped creates a cache holding at most capacity entries with keys of type
+// K and values of type V. It panics if capacity is not positive.
+func NewTyped[K comparable, V any](capacity int, opts ...Option) *TypedCache[K, V] {
+	if capacity <= 0 {
+		panic("cache: capacity must be positive")
+	}
+	o := options{clock: realClock{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	c := &TypedCache[K, V]{
+		clock:       o.clock,
+		shared:      o.shared,
+		capacity:    capacity,
+		maxCost:     o.maxCost,
+		negativeTTL: o.negativeTTL,
+		items:       make(map[K]*entry[K, V], capacity),
+	}
+	if o.sweepInterval > 0 {
+		c.stop = make(chan struct{})
+		c.stopped = make(chan struct{})
+		go c.sweeper(o.sweepInterval)
+	}
+	return c
+}
+
+// SetOnEvict registers fn to be called with each entry the cache drops from
+// then on: entries evicted to make room, expired entries when they are
+// removed, and entries deleted by Remove or Clear. Overwriting a key's value
+// does not call it. fn runs after the cache's lock is released, so it may use
+// the cache. A nil fn removes the callback.
+func (c *TypedCache[K, V]) SetOnEvict(fn func(key K, value V)) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.onEvict = fn
+}
+
+// Close stops the background sweeper, if any, and waits for it to return.
+// The cache remains usable afterwards, with expiry reverting to lazy. Close
+// may be called more than once.
+func (c *TypedCache[K, V]) Close() {
+	if c.stop == nil {
+		return
+	}
+	c.closeOnce.Do(func() { close(c.stop) })
+	<-c.stopped
+}
+
+// sweeper calls sweep every interval until Close is called.
+func (c *TypedCache[K, V]) sweeper(interval time.Duration) {
+	defer close(c.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.sweep()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// sweep removes every expired entry.
+func (c *TypedCache[K, V]) sweep() {
+	c.mu.Lock()
+	defer c.unlock()
+	for e := c.tail; e != nil; {
+		prev := e.prev
+		c.expire(e)
+		e = prev
+	}
+}
+
+// Get fetches a value, marking the key as recently used. An entry whose TTL
+// has passed is removed and reported as missing.
+func (c *TypedCache[K, V]) Get(key K) (V, bool) {
+	if c.shared {
+		return c.getShared(key)
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	return c.get(key)
+}
+
+// getShared implements Get for WithConcurrentReads. Only an expired entry,
+// which must be unlinked, needs the exclusive lock.
+func (c *TypedCache[K, V]) getShared(key K) (V, bool) {
+	c.mu.RLock()
+	e, ok := c.items[key]
+	if ok && !e.expired(c.clock.Now()) {
+		value := e.value
+		e.reads.Add(1)
+		if !e.referenced.Load() {
+			e.referenced.Store(true)
+		}
+		c.mu.RUnlock()
+		c.stats.hits.Add(1)
+		return value, true
+	}
+	c.mu.RUnlock()
+	if !ok {
+		c.stats.misses.Add(1)
+		var zero V
+		return zero, false
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	return c.get(key)
+}
+
+// get implements Get. c.mu must be held.
+func (c *TypedCache[K, V]) get(key K) (V, bool) {
+	e, ok := c.items[key]
+	if !ok || c.expire(e) {
+		c.stats.misses.Add(1)
+		var zero V
+		return zero, false
+	}
+	c.stats.hits.Add(1)
+	e.reads.Add(1)
+	c.touch(e)
+	return e.value, true
+}
+
+// Peek fetches a value like Get without marking it as recently used, so
+// inspecting the cache does not change what it evicts next.
+func (c *TypedCache[K, V]) Peek(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	e, ok := c.items[key]
+	if !ok || c.expire(e) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Oldest returns the least recently used live entry, the next to be evicted,
+// without marking it as recently used. ok is false if the cache is empty.
+// Expired entries found at that end are removed, as by Peek. Under
+// WithConcurrentReads an entry read since it reached the tail is still
+// reported, though eviction would give it a second chance, and under
+// SetEvictionPolicy the policy may pick a different victim.
+func (c *TypedCache[K, V]) Oldest() (key K, value V, ok bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	for c.tail != nil && c.expire(c.tail) {
+	}
+	if c.tail == nil {
+		return key, value, false
+	}
+	return c.tail.key, c.tail.value, true
+}
+
+// Newest returns the most recently used live entry without otherwise
+// changing the order, like Oldest at the other end of the list.
+func (c *TypedCache[K, V]) Newest() (key K, value V, ok bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	for c.head != nil && c.expire(c.head) {
+	}
+	if c.head == nil {
+		return key, value, false
+	}
+	return c.head.key, c.head.value, true
+}
+
+// Contains reports whether key holds a live entry, without marking it as
+// recently used. Unlike Peek it leaves an expired entry in place for a later
+// call to remove, so it never allocates, and it takes only a read lock.
+func (c *TypedCache[K, V]) Contains(key K) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.items[key]
+	return ok && !e.expired(c.clock.Now())
+}
+
+// Frequency returns how many times Get, or a method built on it such as
+// GetMany or GetOrCompute, has found key's value since it was stored, and 0
+// if key holds no live entry. Peek and Contains are not counted, so that
+// inspecting the cache does not skew the figures, and storing a value for the
+// key again restarts the count. It is informational only: the count plays no
+// part in eviction.
+func (c *TypedCache[K, V]) Frequency(key K) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.items[key]
+	if !ok || e.expired(c.clock.Now()) {
+		return 0
+	}
+	return int(e.reads.Load())
+}
+
+// Set inserts or updates a value that never expires, evicting the least
+// recently used entry.
+func (c *TypedCache[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, 0)
+}
+
+// SetWithTTL inserts or updates a value like Set that expires once ttl has
+// passed on the cache's clock. Expired entries are dropped lazily, when next
+// looked up. A ttl of zero or less means the entry never expires.
+func (c *TypedCache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.set(key, value, ttl, 1)
+}
+
+// SetWithCost inserts or updates a value like Set, charging cost against the
+// limit set by WithMaxCost; without one the cost is only reported by Cost. A
+// value whose cost alone exceeds the limit is rejected: it is not stored and
+// any existing entry for key is removed, so a stale value is never returned.
+// It panics if cost is negative.
+func (c *TypedCache[K, V]) SetWithCost(key K, value V, cost int64) {
+	if cost < 0 {
+		panic("cache: cost must not be negative")
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	if c.maxCost > 0 && cost > c.maxCost {
+		if e, ok := c.items[key]; ok {
+			c.drop(e)
+		}
+		return
+	}
+	c.set(key, value, 0, cost)
+}
+
+// set implements SetWithTTL and SetWithCost. c.mu must be held.
+func (c *TypedCache[K, V]) set(key K, value V, ttl time.Duration, cost int64) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = c.clock.Now().Add(ttl)
+	}
+	delete(c.misses, key)
+	if e, ok := c.items[key]; ok {
+		e.value, e.expires = value, expires
+		e.reads.Store(0)
+		c.cost += cost - e.cost
+		e.cost = cost
+		c.touch(e)
+		c.fit(c.capacity, c.maxCost)
+		return
+	}
+	// Make room first so that the policy cannot pick the new entry.
+	c.fit(c.capacity-1, c.maxCost-cost)
+	e := &entry[K, V]{key: key, value: value, expires: expires, cost: cost}
+	c.cost += cost
+	c.items[key] = e
+	c.addToFront(e)
+	c.size++
+	if c.policy != nil {
+		c.policy.OnInsert(key)
+	}
+}
+
+// SetMany stores every entry like Set under a single acquisition of the
+// lock. Entries are inserted in Go's map iteration order, which is random,
+// so when they do not all fit which of them survive is unspecified; existing
+// entries are evicted first in the usual order.
+func (c *TypedCache[K, V]) SetMany(entries map[K]V) {
+	c.mu.Lock()
+	defer c.unlock()
+	for key, value := range entries {
+		c.set(key, value, 0, 1)
+	}
+}
+
+// GetMany looks up keys like Get under a single acquisition of the lock and
+// returns the live entries found, promoting them in the order given. Missing
+// keys are absent from the result.
+func (c *TypedCache[K, V]) GetMany(keys []K) map[K]V {
+	c.mu.Lock()
+	defer c.unlock()
+	found := make(map[K]V, len(keys))
+	for _, key := range keys {
+		if value, ok := c.get(key); ok {
+			found[key] = value
+		}
+	}
+	return found
+}
+
+// Remove deletes key from the cache and reports whether it held a live
+// entry.
+func (c *TypedCache[K, V]) Remove(key K) bool {
+	c.mu.Lock()
+	defer c.unlock()
+	delete(c.misses, key)
+	e, ok := c.items[key]
+	if !ok || c.expire(e) {
+		return false
+	}
+	c.drop(e)
+	return true
+}
+
+// Demote marks key as the least recently used entry, so that it is the next
+// to be evicted unless it is read again, and reports whether it held a live
+// entry. It is the opposite of the promotion Get performs. Under
+// SetEvictionPolicy the policy alone picks what to evict, so Demote then
+// changes only the order Range and Keys report.
+func (c *TypedCache[K, V]) Demote(key K) bool {
+	c.mu.Lock()
+	defer c.unlock()
+	e, ok := c.items[key]
+	if !ok || c.expire(e) {
+		return false
+	}
+	e.referenced.Store(false)
+	c.moveToBack(e)
+	return true
+}
+
+// Clear removes every entry.
+func (c *TypedCache[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.unlock()
+	c.clear()
+}
+
+// clear implements Clear. c.mu must be held.
+func (c *TypedCache[K, V]) clear() {
+	for e := c.head; e != nil; e = e.next {
+		if c.policy != nil {
+			c.policy.OnRemove(e.key)
+		}
+		if c.onEvict != nil {
+			c.evicted = append(c.evicted, e)
+		}
+	}
+	clear(c.items)
+	clear(c.misses)
+	c.head, c.tail = nil, nil
+	c.size = 0
+	c.cost = 0
+}
+
+// Range calls f for each live entry from most to least recently used,
+// stopping early if f returns false. It does not change LRU order. f runs
+// with the cache's lock held, so it must not call methods on the cache.
+func (c *TypedCache[K, V]) Range(f func(key K, value V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	for e := c.head; e != nil; e = e.next {
+		if e.expired(now) {
+			continue
+		}
+		if !f(e.key, e.value) {
+			return
+		}
+	}
+}
+
+// Keys returns the keys of the live entries from most to least recently
+// used. The slice is a copy the caller may keep and modify.
+func (c *TypedCache[K, V]) Keys() []K {
+	var keys []K
+	c.Range(func(key K, _ V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Values returns the values of the live entries in the same order as Keys.
+// The slice is a copy, though values that are themselves references still
+// share their contents with the cache.
+func (c *TypedCache[K, V]) Values() []V {
+	var values []V
+	c.Range(func(_ K, value V) bool {
+		values = append(values, value)
+		return true
+	})
+	return values
+}
+
+// Resize changes the maximum number of entries. Shrinking evicts least
+// recently used entries until the cache fits. It panics if capacity is not
+// positive, like New.
+func (c *TypedCache[K, V]) Resize(capacity int) {
+	if capacity <= 0 {
+		panic("cache: capacity must be positive")
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	c.capacity = capacity
+	c.fit(capacity, c.maxCost)
+}
+
+// Len returns the current number of entries. Because expiry is lazy this
+// includes expired entries that have not been looked up, or swept, since
+// their TTL passed.
+func (c *TypedCache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Cost returns the total cost of the current entries, including expired ones
+// that have not yet been removed.
+func (c *TypedCache[K, V]) Cost() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cost
+}
+
+// entry is a node in the cache's doubly linked list.
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time // zero if the entry never expires
+	cost    int64
+	// referenced is set by Get under WithConcurrentReads in place of
+	// moving the entry to the front.
+	referenced atomic.Bool
+	reads      atomic.Int64 // Get hits since the value was stored
+	prev       *entry[K, V]
+	next       *entry[K, V]
+}
+
+// expired reports whether e's TTL has passed at now.
+func (e *entry[K, V]) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// expire drops e if its TTL has passed and reports whether it did.
+func (c *TypedCache[K, V]) expire(e *entry[K, V]) bool {
+	if !e.expired(c.clock.Now()) {
+		return false
+	}
+	c.drop(e)
+	return true
+}
+
+// fit evicts entries until at most n remain and, if the cache has a cost
+// limit, their total cost is at most budget.
+func (c *TypedCache[K, V]) fit(n int, budget int64) {
+	for (c.size > n || c.maxCost > 0 && c.cost > budget) && c.evictOne() {
+		c.stats.evictions.Add(1)
+	}
+}
+
+// evictOne drops the entry chosen by the eviction policy, or by default the
+// least recently used one, and reports whether there was one.
+func (c *TypedCache[K, V]) evictOne() bool {
+	if c.policy == nil {
+		if c.tail == nil {
+			return false
+		}
+		for c.shared && c.tail.referenced.Swap(false) {
+			c.moveToFront(c.tail)
+		}
+		c.discard(c.tail)
+		return true
+	}
+	for {
+		key, ok := c.policy.Evict()
+		if !ok {
+			return false
+		}
+		if e, ok := c.items[key]; ok {
+			c.discard(e)
+			return true
+		}
+	}
+}
+
+// touch records a use of e.
+func (c *TypedCache[K, V]) touch(e *entry[K, V]) {
+	c.moveToFront(e)
+	if c.policy != nil {
+		c.policy.OnAccess(e.key)
+	}
+}
+
+// drop removes e from the cache and from the eviction policy.
+func (c *TypedCache[K, V]) drop(e *entry[K, V]) {
+	c.discard(e)
+	if c.policy != nil {
+		c.policy.OnRemove(e.key)
+	}
+}
+
+// discard unlinks e and forgets its key, queueing it for the OnEvict callback.
+func (c *TypedCache[K, V]) discard(e *entry[K, V]) {
+	c.remove(e)
+	delete(c.items, e.key)
+	c.size--
+	c.cost -= e.cost
+	if c.onEvict != nil {
+		c.evicted = append(c.evicted, e)
+	}
+}
+
+// unlock releases c.mu and then passes the entries dropped while it was held
+// to the OnEvict callback.
+func (c *TypedCache[K, V]) unlock() {
+	evicted, onEvict := c.evicted, c.onEvict
+	c.evicted = nil
+	c.mu.Unlock()
+	for _, e := range evicted {
+		onEvict(e.key, e.value)
+	}
+}
+
+// addToFront links e in as the most recently used entry.
+func (c *TypedCache[K, V]) addToFront(e *entry[K, V]) {
+	if c.head == nil {
+		c.head, c.tail = e, e
+		return
+	}
+	e.next = c.head
+	c.head.prev = e
+	c.head = e
 }
 
 // remove unlinks e from the list.
-func (c *Cache) remove(e *entry) {
-   if e.prev != nil {
-       e.prev.next = e.next
-   } else {
-       c.head = e.next
-   }
-   if e.next != nil {
-       e.next.prev = e.prev
-   } else {
-       c.tail = e.prev
-   }
-   e.prev, e.next = nil, nil
-}
-
-// moveToFront moves e to the head.
-func (c *Cache) moveToFront(e *entry) {
-   if c.head == e {
-       return
-   }
-   c.remove(e)
-   c.addToFront(e)
-}
-    panic("not implemented")
-}
-
-// Len returns the current number of entries.
-func (c *Cache) Len() int {
-    // TODO: implement
-    panic("not implemented")
+func (c *TypedCache[K, V]) remove(e *entry[K, V]) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		c.head = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else {
+		c.tail = e.prev
+	}
+	e.prev, e.next = nil, nil
+}
+
+// moveToFront makes e the most recently used entry.
+func (c *TypedCache[K, V]) moveToFront(e *entry[K, V]) {
+	if c.head == e {
+		return
+	}
+	c.remove(e)
+	c.addToFront(e)
+}
+
+// moveToBack makes e the least recently used entry.
+func (c *TypedCache[K, V]) moveToBack(e *entry[K, V]) {
+	if c.tail == e {
+		return
+	}
+	c.remove(e)
+	e.prev = c.tail
+	c.tail.next = e
+	c.tail = e
 }
@@ -1,8 +1,13 @@
 package cache
 
 import (
+    "errors"
+    "fmt"
+    "strings"
     "sync"
+    "sync/atomic"
     "testing"
+    "time"
 )
 
 func TestNewPanicsOnInvalidCapacity(t *testing.T) {
@@ -94,4 +99,943 @@ func TestConcurrentReadersAndWriters(t *testing.T) {
     if c.Len() > 5 {
         t.Fatalf("cache size should not exceed capacity")
     }
+    if stats := c.Stats(); stats != (Stats{Hits: 50}) {
+        t.Fatalf("expected 50 hits and nothing else, got %+v", stats)
+    }
+}
+
+func TestTypedCacheNeedsNoAssertions(t *testing.T) {
+    c := NewTyped[int, []string](2)
+    c.Set(1, []string{"one"})
+    c.Set(2, []string{"two"})
+    c.Get(1)
+    c.Set(3, []string{"three"})
+
+    if words, ok := c.Get(1); !ok || words[0] != "one" {
+        t.Fatalf("expected to fetch 1, got %v %v", words, ok)
+    }
+    if words, ok := c.Get(2); ok || words != nil {
+        t.Fatalf("expected 2 to be evicted and a zero value, got %v", words)
+    }
+    if c.Len() != 2 {
+        t.Fatalf("expected len 2, got %d", c.Len())
+    }
+}
+
+type fakeClock struct {
+    mu  sync.Mutex
+    now time.Time
+}
+
+func newFakeClock() *fakeClock {
+    return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = c.now.Add(d)
+}
+
+func TestSetWithTTLExpiresLazily(t *testing.T) {
+    clock := newFakeClock()
+    c := New(3, WithClock(clock))
+    c.SetWithTTL("short", 1, time.Second)
+    c.SetWithTTL("long", 2, time.Minute)
+    c.Set("forever", 3)
+
+    clock.Advance(time.Second)
+    // Expiry is lazy, so the dead entry still counts until it is looked up.
+    if c.Len() != 3 {
+        t.Fatalf("expected len 3 before lookup, got %d", c.Len())
+    }
+    if _, ok := c.Get("short"); ok {
+        t.Fatalf("expected short to have expired")
+    }
+    if c.Len() != 2 {
+        t.Fatalf("expected the expired entry to be unlinked, len %d", c.Len())
+    }
+    if val, ok := c.Get("long"); !ok || val.(int) != 2 {
+        t.Fatalf("expected long to still be live")
+    }
+
+    clock.Advance(time.Hour)
+    if _, ok := c.Get("forever"); !ok {
+        t.Fatalf("entries set without a TTL should never expire")
+    }
+    if _, ok := c.Get("long"); ok {
+        t.Fatalf("expected long to have expired")
+    }
+
+    // Overwriting with Set clears a previous TTL.
+    c.SetWithTTL("k", 1, time.Second)
+    c.Set("k", 2)
+    clock.Advance(time.Minute)
+    if val, ok := c.Get("k"); !ok || val.(int) != 2 {
+        t.Fatalf("expected k to outlive its earlier TTL")
+    }
+}
+
+func TestSweeperReclaimsExpiredEntries(t *testing.T) {
+    clock := newFakeClock()
+    c := NewWithSweeper(3, time.Millisecond, WithClock(clock))
+    defer c.Close()
+    c.SetWithTTL("a", 1, time.Second)
+    c.SetWithTTL("b", 2, time.Second)
+    c.SetWithTTL("c", 3, time.Hour)
+
+    clock.Advance(time.Minute)
+    deadline := time.Now().Add(time.Second)
+    for c.Len() != 1 {
+        if time.Now().After(deadline) {
+            t.Fatalf("expected the sweeper to reclaim expired entries, len %d", c.Len())
+        }
+        time.Sleep(time.Millisecond)
+    }
+    if _, ok := c.Get("c"); !ok {
+        t.Fatalf("live entry should survive the sweep")
+    }
+}
+
+func TestCloseStopsSweeperAndIsIdempotent(t *testing.T) {
+    c := NewWithSweeper(2, time.Millisecond)
+    var wg sync.WaitGroup
+    for i := 0; i < 4; i++ {
+        wg.Add(1)
+        go func(idx int) {
+            defer wg.Done()
+            for j := 0; j < 100; j++ {
+                key := string(rune('a' + (idx+j)%3))
+                c.SetWithTTL(key, j, time.Microsecond)
+                c.Get(key)
+            }
+        }(i)
+    }
+    wg.Wait()
+    c.Close()
+    c.Close()
+    New(1).Close()
+}
+
+func TestPeekDoesNotPromote(t *testing.T) {
+    c := New(2)
+    c.Set("old", 1)
+    c.Set("new", 2)
+    for i := 0; i < 3; i++ {
+        if val, ok := c.Peek("old"); !ok || val.(int) != 1 {
+            t.Fatalf("expected to peek at old")
+        }
+    }
+    c.Set("newer", 3)
+    if _, ok := c.Peek("old"); ok {
+        t.Fatalf("peeking should not have saved old from eviction")
+    }
+
+    c = New(2)
+    c.Set("old", 1)
+    c.Set("new", 2)
+    c.Get("old")
+    c.Set("newer", 3)
+    if _, ok := c.Peek("old"); !ok {
+        t.Fatalf("get should have saved old from eviction")
+    }
+    if _, ok := c.Peek("new"); ok {
+        t.Fatalf("expected new to be evicted instead")
+    }
+}
+
+func TestRemoveKeepsEvictionOrder(t *testing.T) {
+    c := New(3)
+    c.Set("a", 1)
+    c.Set("b", 2)
+    c.Set("c", 3)
+
+    if !c.Remove("b") {
+        t.Fatalf("expected b to be removed")
+    }
+    if c.Remove("b") {
+        t.Fatalf("removing a missing key should report false")
+    }
+    if c.Len() != 2 {
+        t.Fatalf("expected len 2 after remove, got %d", c.Len())
+    }
+
+    c.Set("d", 4)
+    c.Set("e", 5)
+    if _, ok := c.Get("a"); ok {
+        t.Fatalf("expected a to be evicted as least recently used")
+    }
+    for _, key := range []string{"c", "d", "e"} {
+        if _, ok := c.Get(key); !ok {
+            t.Fatalf("expected %s to be present", key)
+        }
+    }
+}
+
+func TestClearEmptiesCache(t *testing.T) {
+    c := New(2)
+    c.Set("a", 1)
+    c.Set("b", 2)
+    c.Clear()
+    if c.Len() != 0 {
+        t.Fatalf("expected empty cache, len %d", c.Len())
+    }
+    if _, ok := c.Get("a"); ok {
+        t.Fatalf("expected a to be gone after clear")
+    }
+
+    c.Set("x", 1)
+    c.Set("y", 2)
+    c.Set("z", 3)
+    if _, ok := c.Get("x"); ok || c.Len() != 2 {
+        t.Fatalf("expected normal eviction after clear")
+    }
+}
+
+func TestOnEvictReceivesEvictedEntry(t *testing.T) {
+    var c *Cache
+    var keys []string
+    var values []any
+    c = New(2)
+    c.SetOnEvict(func(key string, value any) {
+        // The lock is released before the callback, so re-entry is safe.
+        c.Len()
+        keys = append(keys, key)
+        values = append(values, value)
+    })
+    c.Set("a", 1)
+    c.Set("b", 2)
+    c.Get("a")
+    c.Set("c", 3)
+
+    if len(keys) != 1 || keys[0] != "b" || values[0].(int) != 2 {
+        t.Fatalf("expected b=2 to be evicted, got %v %v", keys, values)
+    }
+
+    c.Remove("a")
+    c.Clear()
+    if len(keys) != 3 || keys[1] != "a" || keys[2] != "c" {
+        t.Fatalf("expected Remove and Clear to report a and c, got %v", keys)
+    }
+}
+
+func TestResizeShrinksInLRUOrder(t *testing.T) {
+    var evicted []string
+    c := New(4)
+    c.SetOnEvict(func(key string, _ any) { evicted = append(evicted, key) })
+    for _, key := range []string{"a", "b", "c", "d"} {
+        c.Set(key, key)
+    }
+    c.Get("a")
+
+    c.Resize(2)
+    if c.Len() != 2 {
+        t.Fatalf("expected len 2 after shrinking, got %d", c.Len())
+    }
+    if len(evicted) != 2 || evicted[0] != "b" || evicted[1] != "c" {
+        t.Fatalf("expected b then c to be evicted, got %v", evicted)
+    }
+    for _, key := range []string{"a", "d"} {
+        if _, ok := c.Peek(key); !ok {
+            t.Fatalf("expected %s to survive the shrink", key)
+        }
+    }
+
+    c.Set("e", "e")
+    if _, ok := c.Peek("d"); ok {
+        t.Fatalf("expected the new capacity to apply to later sets")
+    }
+}
+
+func TestResizeGrows(t *testing.T) {
+    c := New(1)
+    c.Set("a", 1)
+    c.Resize(3)
+    c.Set("b", 2)
+    c.Set("c", 3)
+    if c.Len() != 3 {
+        t.Fatalf("expected len 3 after growing, got %d", c.Len())
+    }
+
+    defer func() {
+        if r := recover(); r == nil {
+            t.Fatalf("expected panic on non-positive capacity")
+        }
+    }()
+    c.Resize(0)
+}
+
+func TestStatsCountHitsMissesAndEvictions(t *testing.T) {
+    c := New(2)
+    c.Set("a", 1)
+    c.Set("b", 2)
+    c.Get("a")
+    c.Get("missing")
+    c.Set("c", 3)
+    c.Get("b")
+    c.Remove("a")
+
+    want := Stats{Hits: 1, Misses: 2, Evictions: 1}
+    if stats := c.Stats(); stats != want {
+        t.Fatalf("expected %+v, got %+v", want, stats)
+    }
+}
+
+func TestGetOrComputeLoadsOnce(t *testing.T) {
+    c := New(4)
+    var calls atomic.Int32
+    release := make(chan struct{})
+    loader := func() (any, error) {
+        calls.Add(1)
+        <-release
+        return "value", nil
+    }
+
+    const callers = 20
+    var wg sync.WaitGroup
+    results := make(chan any, callers)
+    for i := 0; i < callers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            value, err := c.GetOrCompute("key", loader)
+            if err != nil {
+                t.Errorf("unexpected error: %v", err)
+            }
+            results <- value
+        }()
+    }
+    for calls.Load() == 0 {
+        time.Sleep(time.Millisecond)
+    }
+    // Give the other callers a chance to join the load in flight.
+    time.Sleep(10 * time.Millisecond)
+    close(release)
+    wg.Wait()
+    close(results)
+
+    if n := calls.Load(); n != 1 {
+        t.Fatalf("expected a single loader call, got %d", n)
+    }
+    for value := range results {
+        if value != "value" {
+            t.Fatalf("expected every caller to receive the loaded value, got %v", value)
+        }
+    }
+    if value, ok := c.Get("key"); !ok || value != "value" {
+        t.Fatalf("expected the loaded value to be cached")
+    }
+}
+
+func TestGetOrComputeDoesNotCacheErrors(t *testing.T) {
+    c := New(2)
+    boom := errors.New("boom")
+    if _, err := c.GetOrCompute("key", func() (any, error) { return nil, boom }); err != boom {
+        t.Fatalf("expected loader error, got %v", err)
+    }
+    if _, ok := c.Peek("key"); ok {
+        t.Fatalf("failed loads should not be cached")
+    }
+    value, err := c.GetOrCompute("key", func() (any, error) { return 2, nil })
+    if err != nil || value.(int) != 2 {
+        t.Fatalf("expected a retry to load, got %v %v", value, err)
+    }
+}
+
+func TestNegativeTTLCachesMisses(t *testing.T) {
+    clock := newFakeClock()
+    c := New(2, WithClock(clock), WithNegativeTTL(time.Minute))
+    calls := 0
+    missing := func() (any, error) {
+        calls++
+        return nil, fmt.Errorf("lookup: %w", ErrNotFound)
+    }
+    for i := 0; i < 3; i++ {
+        if _, err := c.GetOrCompute("gone", missing); !errors.Is(err, ErrNotFound) {
+            t.Fatalf("expected ErrNotFound, got %v", err)
+        }
+    }
+    if calls != 1 {
+        t.Fatalf("expected the miss to be cached after one load, got %d loads", calls)
+    }
+    if c.Len() != 0 {
+        t.Fatalf("cached misses should not count as entries, len %d", c.Len())
+    }
+
+    clock.Advance(time.Minute)
+    c.GetOrCompute("gone", missing)
+    if calls != 2 {
+        t.Fatalf("expected the loader to run again once the miss expired, got %d loads", calls)
+    }
+
+    // A nil value is a hit, not a miss.
+    value, err := c.GetOrCompute("nil", func() (any, error) { return nil, nil })
+    if err != nil || value != nil {
+        t.Fatalf("expected a cached nil, got %v %v", value, err)
+    }
+    if _, ok := c.Peek("nil"); !ok {
+        t.Fatalf("expected nil value to be stored")
+    }
+
+    c.Set("gone", 1)
+    if value, err := c.GetOrCompute("gone", missing); err != nil || value != 1 {
+        t.Fatalf("expected Set to replace the cached miss, got %v %v", value, err)
+    }
+}
+
+func TestGetOrSetHasOneWinner(t *testing.T) {
+    c := New(2)
+    var wg sync.WaitGroup
+    var inserted atomic.Int64
+    actuals := make([]any, 50)
+    for i := range actuals {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            actual, loaded := c.GetOrSet("key", i)
+            if !loaded {
+                inserted.Add(1)
+            }
+            actuals[i] = actual
+        }(i)
+    }
+    wg.Wait()
+
+    if n := inserted.Load(); n != 1 {
+        t.Fatalf("expected exactly one inserter, got %d", n)
+    }
+    winner, _ := c.Peek("key")
+    for i, actual := range actuals {
+        if actual != winner {
+            t.Fatalf("caller %d saw %v, want the stored %v", i, actual, winner)
+        }
+    }
+}
+
+func TestCompareAndSwapCountsEverySuccess(t *testing.T) {
+    c := New(2)
+    c.Set("counter", 0)
+    c.Set("other", 0)
+    eq := func(a, b any) bool { return a == b }
+    const workers, increments = 20, 50
+    var wg sync.WaitGroup
+    var swaps atomic.Int64
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for n := 0; n < increments; {
+                v, _ := c.Peek("counter")
+                if c.CompareAndSwap("counter", v, v.(int)+1, eq) {
+                    swaps.Add(1)
+                    n++
+                }
+            }
+        }()
+    }
+    wg.Wait()
+
+    if n := swaps.Load(); n != workers*increments {
+        t.Fatalf("expected %d successful swaps, got %d", workers*increments, n)
+    }
+    if v, _ := c.Peek("counter"); v != workers*increments {
+        t.Fatalf("expected no lost updates, got %v", v)
+    }
+    if c.CompareAndSwap("counter", 0, 1, eq) {
+        t.Fatalf("expected a swap from a stale value to fail")
+    }
+    if c.CompareAndSwap("missing", nil, 1, eq) {
+        t.Fatalf("expected a swap on a missing key to fail")
+    }
+    // A successful swap promotes the entry like Set.
+    c.Set("other", 1)
+    c.CompareAndSwap("counter", workers*increments, 0, eq)
+    c.Set("new", 2)
+    if _, ok := c.Peek("other"); ok {
+        t.Fatalf("expected the swapped entry to be promoted past other")
+    }
+}
+
+func TestFrequencyCountsGetsSinceSet(t *testing.T) {
+    for _, c := range []*Cache{New(2), NewConcurrent(2)} {
+        c.Set("a", 1)
+        if n := c.Frequency("a"); n != 0 {
+            t.Fatalf("expected a fresh entry to have no reads, got %d", n)
+        }
+        c.Get("a")
+        c.Get("a")
+        c.GetMany([]string{"a", "missing"})
+        c.Peek("a")
+        c.Contains("a")
+        if n := c.Frequency("a"); n != 3 {
+            t.Fatalf("expected Gets but not Peeks to count, got %d", n)
+        }
+        c.Set("a", 2)
+        if n := c.Frequency("a"); n != 0 {
+            t.Fatalf("expected Set to restart the count, got %d", n)
+        }
+        c.Get("a")
+        c.Set("b", 1)
+        c.Set("c", 1)
+        c.Set("a", 3)
+        if n := c.Frequency("a"); n != 0 {
+            t.Fatalf("expected an evicted key to start again from zero, got %d", n)
+        }
+        if n := c.Frequency("missing"); n != 0 {
+            t.Fatalf("expected 0 for a missing key, got %d", n)
+        }
+    }
+}
+
+func TestOldestAndNewestTrackRecency(t *testing.T) {
+    clock := newFakeClock()
+    c := New(4, WithClock(clock))
+    if _, _, ok := c.Oldest(); ok {
+        t.Fatalf("expected no oldest entry in an empty cache")
+    }
+    if _, _, ok := c.Newest(); ok {
+        t.Fatalf("expected no newest entry in an empty cache")
+    }
+    c.Set("a", 1)
+    c.Set("b", 2)
+    c.Set("c", 3)
+    check := func(oldest, newest string) {
+        t.Helper()
+        if k, _, _ := c.Oldest(); k != oldest {
+            t.Fatalf("expected oldest %q, got %q", oldest, k)
+        }
+        if k, _, _ := c.Newest(); k != newest {
+            t.Fatalf("expected newest %q, got %q", newest, k)
+        }
+    }
+    check("a", "c")
+    c.Get("a")
+    check("b", "a")
+    // Asking does not promote.
+    check("b", "a")
+    c.Peek("b")
+    check("b", "a")
+    if k, v, ok := c.Oldest(); k != "b" || v != 2 || !ok {
+        t.Fatalf("expected b=2, got %s=%v ok=%v", k, v, ok)
+    }
+    // An expired entry at the tail is dropped rather than reported.
+    c.SetWithTTL("d", 4, time.Second)
+    c.Demote("d")
+    clock.Advance(time.Second)
+    check("b", "a")
+    if c.Len() != 3 {
+        t.Fatalf("expected the expired entry to be removed, len %d", c.Len())
+    }
+}
+
+func TestDemoteMakesEntryNextToEvict(t *testing.T) {
+    for _, c := range []*Cache{New(3), NewConcurrent(3)} {
+        c.Set("a", 1)
+        c.Set("b", 2)
+        c.Set("c", 3)
+        c.Get("c")
+        if !c.Demote("c") {
+            t.Fatalf("expected Demote to find c")
+        }
+        c.Set("d", 4)
+        if _, ok := c.Peek("c"); ok {
+            t.Fatalf("expected the demoted entry to be evicted first")
+        }
+        if strings.Join(c.Keys(), "") != "dba" {
+            t.Fatalf("expected the other entries to survive, got %v", c.Keys())
+        }
+        if c.Demote("missing") {
+            t.Fatalf("expected Demote to report a missing key")
+        }
+    }
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+    clock := newFakeClock()
+    c := New(4, WithClock(clock))
+    c.Set("a", "x")
+    c.SetWithTTL("b", 2, time.Minute)
+    c.Set("c", []any{true, nil})
+    c.SetWithTTL("gone", 4, time.Second)
+    c.Get("a")
+    clock.Advance(time.Second)
+
+    data, err := c.Snapshot()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    restored := New(4, WithClock(clock))
+    restored.Set("stale", 0)
+    if err := restored.Load(data); err != nil {
+        t.Fatal(err)
+    }
+    if strings.Join(restored.Keys(), "") != "acb" {
+        t.Fatalf("expected recency order acb, got %v", restored.Keys())
+    }
+    if v, _ := restored.Peek("a"); v != "x" {
+        t.Fatalf("expected a=x, got %v", v)
+    }
+    // Values come back as JSON's default types.
+    if v, _ := restored.Peek("b"); v != float64(2) {
+        t.Fatalf("expected b=2, got %#v", v)
+    }
+    if v, _ := restored.Peek("c"); fmt.Sprint(v) != "[true <nil>]" {
+        t.Fatalf("expected c to round-trip, got %#v", v)
+    }
+    clock.Advance(time.Minute)
+    if _, ok := restored.Get("b"); ok {
+        t.Fatalf("expected b to keep its expiry")
+    }
+
+    // A smaller cache keeps the most recently used entries.
+    small := New(2)
+    if err := small.Load(data); err != nil {
+        t.Fatal(err)
+    }
+    if strings.Join(small.Keys(), "") != "ac" {
+        t.Fatalf("expected ac to fit, got %v", small.Keys())
+    }
+
+    if err := small.Load([]byte("not json")); err == nil {
+        t.Fatalf("expected a decode error")
+    }
+    if small.Len() != 2 {
+        t.Fatalf("a failed load should leave the cache alone, len %d", small.Len())
+    }
+    if _, err := New(1).Snapshot(); err != nil {
+        t.Fatalf("expected an empty snapshot, got %v", err)
+    }
+}
+
+func TestRangeVisitsInRecencyOrder(t *testing.T) {
+    clock := newFakeClock()
+    c := New(4, WithClock(clock))
+    c.Set("a", 1)
+    c.Set("b", 2)
+    c.SetWithTTL("c", 3, time.Second)
+    c.Set("d", 4)
+    c.Get("a")
+    clock.Advance(time.Second)
+
+    var keys []string
+    c.Range(func(key string, value any) bool {
+        keys = append(keys, key)
+        return true
+    })
+    if strings.Join(keys, "") != "adb" {
+        t.Fatalf("expected order adb skipping the expired entry, got %v", keys)
+    }
+
+    keys = nil
+    c.Range(func(key string, value any) bool {
+        keys = append(keys, key)
+        return len(keys) < 2
+    })
+    if strings.Join(keys, "") != "ad" {
+        t.Fatalf("expected range to stop early, got %v", keys)
+    }
+}
+
+func TestConcurrentReadsGiveReferencedEntriesASecondChance(t *testing.T) {
+    c := NewConcurrent(3)
+    c.Set("a", 1)
+    c.Set("b", 2)
+    c.Set("c", 3)
+    c.Get("a")
+
+    c.Set("d", 4)
+    if _, ok := c.Peek("a"); !ok {
+        t.Fatalf("a was read and should have survived")
+    }
+    if _, ok := c.Peek("b"); ok {
+        t.Fatalf("expected b to be evicted as the oldest unread entry")
+    }
+    if c.Len() != 3 {
+        t.Fatalf("expected len 3, got %d", c.Len())
+    }
+}
+
+func TestConcurrentReadsUnderContention(t *testing.T) {
+    c := NewConcurrent(8)
+    var wg sync.WaitGroup
+    for i := 0; i < 8; i++ {
+        wg.Add(2)
+        go func(idx int) {
+            defer wg.Done()
+            for j := 0; j < 200; j++ {
+                c.Set(string(rune('a'+(idx+j)%16)), j)
+            }
+        }(i)
+        go func(idx int) {
+            defer wg.Done()
+            for j := 0; j < 200; j++ {
+                c.Get(string(rune('a' + (idx+j)%16)))
+            }
+        }(i)
+    }
+    wg.Wait()
+    if c.Len() > 8 {
+        t.Fatalf("cache size should not exceed capacity, got %d", c.Len())
+    }
+    if stats := c.Stats(); stats.Hits+stats.Misses != 8*200 {
+        t.Fatalf("expected every get to be counted, got %+v", stats)
+    }
+}
+
+func benchmarkParallelGet(b *testing.B, c *Cache) {
+    keys := make([]string, 64)
+    for i := range keys {
+        keys[i] = string(rune('a' + i))
+        c.Set(keys[i], i)
+    }
+    b.ResetTimer()
+    b.RunParallel(func(pb *testing.PB) {
+        i := 0
+        for pb.Next() {
+            c.Get(keys[i%len(keys)])
+            i++
+        }
+    })
+}
+
+func BenchmarkParallelGet(b *testing.B) {
+    benchmarkParallelGet(b, New(64))
+}
+
+func BenchmarkParallelGetConcurrent(b *testing.B) {
+    benchmarkParallelGet(b, NewConcurrent(64))
+}
+
+func TestKeysAndValuesSnapshot(t *testing.T) {
+    c := New(3)
+    c.Set("a", 1)
+    c.Set("b", 2)
+    c.Set("c", 3)
+    c.Get("a")
+
+    keys, values := c.Keys(), c.Values()
+    if strings.Join(keys, "") != "acb" {
+        t.Fatalf("expected keys in MRU order acb, got %v", keys)
+    }
+    if len(values) != 3 || values[0].(int) != 1 || values[1].(int) != 3 || values[2].(int) != 2 {
+        t.Fatalf("expected values 1 3 2, got %v", values)
+    }
+
+    keys[0], values[0] = "z", 100
+    if val, ok := c.Peek("a"); !ok || val.(int) != 1 {
+        t.Fatalf("mutating the snapshot should not affect the cache")
+    }
+    if _, ok := c.Peek("z"); ok {
+        t.Fatalf("mutating the snapshot should not add keys")
+    }
+}
+
+func TestFIFOPolicyIgnoresAccesses(t *testing.T) {
+    c := New(2)
+    c.SetEvictionPolicy(NewFIFO[string]())
+    c.Set("a", 1)
+    c.Set("b", 2)
+    c.Get("a")
+    c.Set("c", 3)
+
+    if _, ok := c.Peek("a"); ok {
+        t.Fatalf("expected a to be evicted as the oldest insert despite being read")
+    }
+
+    c.Remove("b")
+    c.Set("d", 4)
+    c.Set("e", 5)
+    if strings.Join(c.Keys(), "") != "ed" {
+        t.Fatalf("expected c to be evicted next, got %v", c.Keys())
+    }
+}
+
+func TestLFUPolicyEvictsLeastFrequentlyUsed(t *testing.T) {
+    var evicted []string
+    c := New(3)
+    c.SetEvictionPolicy(NewLFU[string]())
+    c.SetOnEvict(func(key string, _ any) { evicted = append(evicted, key) })
+    c.Set("a", 1)
+    c.Set("b", 2)
+    c.Set("c", 3)
+    c.Get("a")
+    c.Get("a")
+    c.Get("b")
+    c.Get("c")
+
+    // b and c tie on two uses each; b was used least recently. A new
+    // entry is never the one evicted to make room for itself.
+    c.Set("d", 4)
+    c.Set("e", 5)
+    c.Get("e")
+    c.Set("f", 6)
+    if strings.Join(evicted, "") != "bdc" {
+        t.Fatalf("expected b, d then c to be evicted, got %v", evicted)
+    }
+
+    c.Remove("a")
+    c.Clear()
+    c.Set("x", 1)
+    if c.Len() != 1 {
+        t.Fatalf("expected the policy to be usable after clear, len %d", c.Len())
+    }
+}
+
+func TestTwoQueueSurvivesScan(t *testing.T) {
+    lru, twoQ := New(8), New2Q(8)
+    for _, c := range []*Cache{lru, twoQ} {
+        c.Set("hot", 1)
+        c.Get("hot")
+        for i := 0; i < 100; i++ {
+            c.Set(fmt.Sprint("scan", i), i)
+        }
+        if c.Len() != 8 {
+            t.Fatalf("expected 8 entries, got %d", c.Len())
+        }
+    }
+    if _, ok := lru.Get("hot"); ok {
+        t.Fatalf("expected the scan to flush the hot key from a plain LRU")
+    }
+    if _, ok := twoQ.Get("hot"); !ok {
+        t.Fatalf("expected the hot key to survive the scan under 2Q")
+    }
+    // The scan's most recent keys fill the admission queue.
+    if _, ok := twoQ.Peek("scan99"); !ok {
+        t.Fatalf("expected the newest scan key to be cached")
+    }
+}
+
+func TestEvictionPolicyRejectsConcurrentReads(t *testing.T) {
+    defer func() {
+        if r := recover(); r == nil {
+            t.Fatalf("expected panic combining a policy with concurrent reads")
+        }
+    }()
+    NewConcurrent(2).SetEvictionPolicy(NewLFU[string]())
+}
+
+func TestSetEvictionPolicyAdoptsExistingEntries(t *testing.T) {
+    var evicted []int
+    c := NewTyped[int, string](3)
+    c.SetOnEvict(func(key int, _ string) { evicted = append(evicted, key) })
+    c.Set(1, "a")
+    c.Set(2, "b")
+    c.Set(3, "c")
+    c.Get(1)
+
+    // FIFO learns the keys in LRU order, so 2 and 3 are now the oldest.
+    c.SetEvictionPolicy(NewFIFO[int]())
+    c.Set(4, "d")
+    c.Get(3)
+    c.Set(5, "e")
+    if len(evicted) != 2 || evicted[0] != 2 || evicted[1] != 3 {
+        t.Fatalf("expected 2 then 3 to be evicted, got %v", evicted)
+    }
+
+    c.SetEvictionPolicy(nil)
+    c.Get(1)
+    c.Set(6, "f")
+    if len(evicted) != 3 || evicted[2] != 4 {
+        t.Fatalf("expected LRU eviction of 4 after clearing the policy, got %v", evicted)
+    }
+}
+
+func TestMaxCostEvictsUntilCostFits(t *testing.T) {
+    c := NewWithMaxCost(10)
+    c.SetWithCost("a", "aaaa", 4)
+    c.SetWithCost("b", "bbbb", 4)
+    c.Get("a")
+    c.SetWithCost("c", "cc", 2)
+    if c.Cost() != 10 || c.Len() != 3 {
+        t.Fatalf("expected cost 10 over 3 entries, got %d over %d", c.Cost(), c.Len())
+    }
+
+    c.SetWithCost("d", "ddddd", 5)
+    if _, ok := c.Peek("b"); ok {
+        t.Fatalf("expected b to be evicted as least recently used")
+    }
+    if _, ok := c.Peek("a"); ok {
+        t.Fatalf("expected a to be evicted once b was not enough")
+    }
+    if c.Cost() != 7 || c.Len() != 2 {
+        t.Fatalf("expected cost 7 over 2 entries, got %d over %d", c.Cost(), c.Len())
+    }
+
+    // Growing an existing entry evicts others rather than itself.
+    c.SetWithCost("d", "ddddddddd", 9)
+    if _, ok := c.Peek("c"); ok || c.Cost() != 9 {
+        t.Fatalf("expected c to make way for d, cost %d", c.Cost())
+    }
+}
+
+func TestMaxCostRejectsOversizedValues(t *testing.T) {
+    c := NewWithMaxCost(10)
+    c.SetWithCost("small", 1, 3)
+    c.SetWithCost("big", 2, 4)
+    c.SetWithCost("big", 3, 11)
+
+    if _, ok := c.Peek("big"); ok {
+        t.Fatalf("an oversized value should not be stored or leave a stale one")
+    }
+    if _, ok := c.Peek("small"); !ok || c.Cost() != 3 {
+        t.Fatalf("rejecting an oversized value should not evict others, cost %d", c.Cost())
+    }
+}
+
+func TestContainsDoesNotPromote(t *testing.T) {
+    clock := newFakeClock()
+    c := New(2, WithClock(clock))
+    c.Set("old", 1)
+    c.SetWithTTL("new", 2, time.Second)
+    if !c.Contains("old") || !c.Contains("new") || c.Contains("missing") {
+        t.Fatalf("unexpected membership")
+    }
+    if n := testing.AllocsPerRun(100, func() { c.Contains("old") }); n != 0 {
+        t.Fatalf("expected Contains not to allocate, got %v allocations", n)
+    }
+
+    c.Set("newer", 3)
+    if c.Contains("old") {
+        t.Fatalf("checking membership should not have saved old from eviction")
+    }
+
+    clock.Advance(time.Second)
+    if c.Contains("new") {
+        t.Fatalf("expected an expired entry to be reported absent")
+    }
+}
+
+func TestSetManyHonoursCapacity(t *testing.T) {
+    c := New(3)
+    c.Set("old", 0)
+    bulk := map[string]any{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}
+    c.SetMany(bulk)
+
+    keys := c.Keys()
+    if len(keys) != 3 {
+        t.Fatalf("expected exactly 3 entries, got %v", keys)
+    }
+    for _, key := range keys {
+        if _, ok := bulk[key]; !ok {
+            t.Fatalf("expected only bulk entries to survive, found %s", key)
+        }
+    }
+
+    found := c.GetMany([]string{keys[2], "missing", keys[1]})
+    if len(found) != 2 || found[keys[2]] != bulk[keys[2]] || found[keys[1]] != bulk[keys[1]] {
+        t.Fatalf("unexpected bulk read %v", found)
+    }
+    if got := c.Keys(); got[0] != keys[1] || got[1] != keys[2] {
+        t.Fatalf("expected GetMany to promote in order, got %v", got)
+    }
+    if stats := c.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+        t.Fatalf("expected bulk reads to count hits and misses, got %+v", stats)
+    }
 }
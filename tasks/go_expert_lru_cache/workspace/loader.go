@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by a GetOrCompute loader to report that key has
+// no value. See WithNegativeTTL.
+var ErrNotFound = errors.New("cache: not found")
+
+// errLoaderPanicked is returned to callers that were waiting on a loader call
+// that panicked. The panic itself propagates in the goroutine that ran it.
+var errLoaderPanicked = errors.New("cache: loader panicked")
+
+// load is a GetOrCompute call in flight. Callers asking for the same key wait
+// on done and then share its result.
+type load[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// WithNegativeTTL makes GetOrCompute remember, for ttl, each key whose
+// loader returned an error wrapping ErrNotFound. Until then GetOrCompute
+// returns ErrNotFound for the key without calling a loader. This is separate
+// from caching a nil value, which a loader does by returning nil with a nil
+// error. Remembered misses do not count towards Len or capacity, but at most
+// capacity of them are kept; storing or removing the key forgets its miss. It
+// panics if ttl is not positive.
+func WithNegativeTTL(ttl time.Duration) Option {
+	if ttl <= 0 {
+		panic("cache: negative TTL must be positive")
+	}
+	return func(o *options) { o.negativeTTL = ttl }
+}
+
+// GetOrCompute returns the value cached for key, calling loader to produce and
+// cache it on a miss. Concurrent calls for the same missing key share a
+// single loader call and all receive its result. Errors are returned to
+// every waiting caller but not cached, so the next call tries again, except
+// for ErrNotFound under WithNegativeTTL. loader runs without the cache's lock
+// held.
+func (c *TypedCache[K, V]) GetOrCompute(key K, loader func() (V, error)) (V, error) {
+	c.mu.Lock()
+	if value, ok := c.get(key); ok {
+		c.unlock()
+		return value, nil
+	}
+	if c.missCached(key) {
+		c.unlock()
+		var zero V
+		return zero, ErrNotFound
+	}
+	if l, ok := c.loads[key]; ok {
+		c.unlock()
+		<-l.done
+		return l.value, l.err
+	}
+	l := &load[V]{done: make(chan struct{})}
+	if c.loads == nil {
+		c.loads = make(map[K]*load[V])
+	}
+	c.loads[key] = l
+	c.unlock()
+
+	returned := false
+	defer func() {
+		if !returned {
+			l.err = errLoaderPanicked
+		}
+		c.mu.Lock()
+		delete(c.loads, key)
+		if l.err == nil {
+			c.set(key, l.value, 0, 1)
+		} else if c.negativeTTL > 0 && errors.Is(l.err, ErrNotFound) {
+			c.rememberMiss(key)
+		}
+		c.unlock()
+		close(l.done)
+	}()
+	l.value, l.err = loader()
+	returned = true
+	return l.value, l.err
+}
+
+// missCached reports whether a miss remembered for key is still live,
+// forgetting it if not. c.mu must be held.
+func (c *TypedCache[K, V]) missCached(key K) bool {
+	until, ok := c.misses[key]
+	if !ok {
+		return false
+	}
+	if !c.clock.Now().Before(until) {
+		delete(c.misses, key)
+		return false
+	}
+	return true
+}
+
+// rememberMiss records a miss for key for the negative TTL, first forgetting
+// expired misses, and if need be an arbitrary live one, to stay within
+// capacity. c.mu must be held.
+func (c *TypedCache[K, V]) rememberMiss(key K) {
+	now := c.clock.Now()
+	if c.misses == nil {
+		c.misses = make(map[K]time.Time)
+	}
+	if len(c.misses) >= c.capacity {
+		for k, until := range c.misses {
+			if !now.Before(until) {
+				delete(c.misses, k)
+			}
+		}
+		for k := range c.misses {
+			if len(c.misses) < c.capacity {
+				break
+			}
+			delete(c.misses, k)
+		}
+	}
+	c.misses[key] = now.Add(c.negativeTTL)
+}
+
+// GetOrSet returns the live value cached for key, marking it as recently
+// used, with loaded true. Otherwise it stores value like Set and returns it
+// with loaded false. The lookup and insert happen under one acquisition of
+// the lock, like sync.Map's LoadOrStore, so of several concurrent calls for
+// a missing key exactly one stores its value and the rest see it.
+func (c *TypedCache[K, V]) GetOrSet(key K, value V) (actual V, loaded bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	if v, ok := c.get(key); ok {
+		return v, true
+	}
+	c.set(key, value, 0, 1)
+	return value, false
+}
+
+// CompareAndSwap stores new for key, like Set, if key holds a live value
+// that eq reports equal to old, and reports whether it did. The comparison
+// and the store happen under one acquisition of the lock, so of several
+// concurrent calls expecting the same old value at most one succeeds. eq
+// runs with the lock held and must not call back into the cache.
+func (c *TypedCache[K, V]) CompareAndSwap(key K, old, new V, eq func(a, b V) bool) bool {
+	c.mu.Lock()
+	defer c.unlock()
+	e, ok := c.items[key]
+	if !ok || c.expire(e) || !eq(e.value, old) {
+		return false
+	}
+	c.set(key, new, 0, 1)
+	return true
+}
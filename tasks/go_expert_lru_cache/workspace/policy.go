@@ -0,0 +1,243 @@
+package cache
+
+import "container/list"
+
+// EvictionPolicy decides which entry a cache evicts when it is full. The
+// cache keeps the entries themselves and tells the policy about each key's
+// life cycle; the policy only tracks ordering. Its methods are called with
+// the cache's lock held, so implementations need no locking of their own and
+// must not call back into the cache.
+type EvictionPolicy[K comparable] interface {
+	// OnInsert is called when key is added to the cache.
+	OnInsert(key K)
+	// OnAccess is called when key is read by Get or overwritten by Set.
+	OnAccess(key K)
+	// OnRemove is called when key leaves the cache other than through
+	// Evict: expiry, Remove or Clear.
+	OnRemove(key K)
+	// Evict picks the key to evict and forgets it, reporting false if the
+	// policy tracks no keys.
+	Evict() (key K, ok bool)
+}
+
+// SetEvictionPolicy makes the cache evict according to p instead of its
+// built-in LRU order, or reverts to that order if p is nil. Entries already
+// cached are passed to p's OnInsert from least to most recently used. A policy
+// instance must not be shared between caches. It panics if the cache was
+// created with WithConcurrentReads.
+func (c *TypedCache[K, V]) SetEvictionPolicy(p EvictionPolicy[K]) {
+	if p != nil && c.shared {
+		panic("cache: WithConcurrentReads cannot be combined with an eviction policy")
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	c.policy = p
+	if p == nil {
+		return
+	}
+	for e := c.tail; e != nil; e = e.prev {
+		p.OnInsert(e.key)
+	}
+}
+
+// FIFO is an EvictionPolicy that evicts the oldest inserted key, ignoring
+// accesses.
+type FIFO[K comparable] struct {
+	order *list.List // keys, oldest first
+	elems map[K]*list.Element
+}
+
+// NewFIFO returns an empty FIFO policy.
+func NewFIFO[K comparable]() *FIFO[K] {
+	return &FIFO[K]{order: list.New(), elems: make(map[K]*list.Element)}
+}
+
+func (p *FIFO[K]) OnInsert(key K) { p.elems[key] = p.order.PushBack(key) }
+
+func (p *FIFO[K]) OnAccess(K) {}
+
+func (p *FIFO[K]) OnRemove(key K) {
+	if el, ok := p.elems[key]; ok {
+		p.order.Remove(el)
+		delete(p.elems, key)
+	}
+}
+
+func (p *FIFO[K]) Evict() (K, bool) {
+	el := p.order.Front()
+	if el == nil {
+		var zero K
+		return zero, false
+	}
+	key := p.order.Remove(el).(K)
+	delete(p.elems, key)
+	return key, true
+}
+
+// LFU is an EvictionPolicy that evicts the least frequently used key,
+// breaking ties by evicting the least recently used among them. Keys are
+// grouped into buckets on a list ordered by access count, so every operation
+// runs in constant time.
+type LFU[K comparable] struct {
+	buckets *list.List // of *lfuBucket, by ascending count
+	nodes   map[K]lfuNode
+}
+
+// lfuBucket holds the keys accessed count times, least recently used first.
+type lfuBucket struct {
+	count int
+	keys  *list.List
+}
+
+// lfuNode locates a key: its bucket on LFU.buckets and its element within
+// that bucket's keys.
+type lfuNode struct {
+	bucket *list.Element
+	elem   *list.Element
+}
+
+// NewLFU returns an empty LFU policy.
+func NewLFU[K comparable]() *LFU[K] {
+	return &LFU[K]{buckets: list.New(), nodes: make(map[K]lfuNode)}
+}
+
+func (p *LFU[K]) OnInsert(key K) {
+	front := p.buckets.Front()
+	if front == nil || front.Value.(*lfuBucket).count != 1 {
+		front = p.buckets.PushFront(&lfuBucket{count: 1, keys: list.New()})
+	}
+	p.nodes[key] = lfuNode{bucket: front, elem: front.Value.(*lfuBucket).keys.PushBack(key)}
+}
+
+func (p *LFU[K]) OnAccess(key K) {
+	n, ok := p.nodes[key]
+	if !ok {
+		return
+	}
+	count := n.bucket.Value.(*lfuBucket).count + 1
+	next := n.bucket.Next()
+	if next == nil || next.Value.(*lfuBucket).count != count {
+		next = p.buckets.InsertAfter(&lfuBucket{count: count, keys: list.New()}, n.bucket)
+	}
+	p.unlink(n)
+	p.nodes[key] = lfuNode{bucket: next, elem: next.Value.(*lfuBucket).keys.PushBack(key)}
+}
+
+func (p *LFU[K]) OnRemove(key K) {
+	if n, ok := p.nodes[key]; ok {
+		p.unlink(n)
+		delete(p.nodes, key)
+	}
+}
+
+func (p *LFU[K]) Evict() (K, bool) {
+	front := p.buckets.Front()
+	if front == nil {
+		var zero K
+		return zero, false
+	}
+	key := front.Value.(*lfuBucket).keys.Front().Value.(K)
+	p.OnRemove(key)
+	return key, true
+}
+
+// unlink takes n's key out of its bucket, dropping the bucket once empty.
+func (p *LFU[K]) unlink(n lfuNode) {
+	b := n.bucket.Value.(*lfuBucket)
+	b.keys.Remove(n.elem)
+	if b.keys.Len() == 0 {
+		p.buckets.Remove(n.bucket)
+	}
+}
+
+// TwoQueue is an EvictionPolicy implementing simplified 2Q, which resists
+// pollution by one-off scans. New keys enter a small FIFO admission queue and
+// only graduate to the main LRU list when accessed again while there, so a
+// pass over many keys that are never reused evicts only other newcomers,
+// leaving the main list's hot keys in place.
+type TwoQueue[K comparable] struct {
+	recentSize int
+	recent     *list.List // keys seen once, oldest first
+	frequent   *list.List // keys seen again, most recently used first
+	nodes      map[K]twoQueueNode
+}
+
+// twoQueueNode locates a key on one of a TwoQueue's lists.
+type twoQueueNode struct {
+	elem     *list.Element
+	frequent bool
+}
+
+// NewTwoQueue returns an empty TwoQueue policy whose admission queue holds
+// up to recentSize keys before they are evicted in preference to the main
+// list. A quarter of the cache's capacity is typical. It panics if
+// recentSize is not positive.
+func NewTwoQueue[K comparable](recentSize int) *TwoQueue[K] {
+	if recentSize <= 0 {
+		panic("cache: 2Q admission queue size must be positive")
+	}
+	return &TwoQueue[K]{
+		recentSize: recentSize,
+		recent:     list.New(),
+		frequent:   list.New(),
+		nodes:      make(map[K]twoQueueNode),
+	}
+}
+
+// New2Q creates a string/any cache like New that evicts by 2Q instead of
+// LRU, with a quarter of capacity given to the admission queue.
+func New2Q(capacity int, opts ...Option) *Cache {
+	c := New(capacity, opts...)
+	c.SetEvictionPolicy(NewTwoQueue[string](max(capacity/4, 1)))
+	return c
+}
+
+func (p *TwoQueue[K]) OnInsert(key K) {
+	p.nodes[key] = twoQueueNode{elem: p.recent.PushBack(key)}
+}
+
+func (p *TwoQueue[K]) OnAccess(key K) {
+	n, ok := p.nodes[key]
+	switch {
+	case !ok:
+	case n.frequent:
+		p.frequent.MoveToFront(n.elem)
+	default:
+		p.recent.Remove(n.elem)
+		p.nodes[key] = twoQueueNode{elem: p.frequent.PushFront(key), frequent: true}
+	}
+}
+
+func (p *TwoQueue[K]) OnRemove(key K) {
+	if n, ok := p.nodes[key]; ok {
+		p.unlink(n)
+		delete(p.nodes, key)
+	}
+}
+
+func (p *TwoQueue[K]) Evict() (K, bool) {
+	// The cache evicts before inserting, so evicting from a full admission
+	// queue keeps it within recentSize once the newcomer arrives.
+	var el *list.Element
+	if p.recent.Len() >= p.recentSize || p.frequent.Len() == 0 {
+		el = p.recent.Front()
+	}
+	if el == nil {
+		el = p.frequent.Back()
+	}
+	if el == nil {
+		var zero K
+		return zero, false
+	}
+	key := el.Value.(K)
+	p.OnRemove(key)
+	return key, true
+}
+
+func (p *TwoQueue[K]) unlink(n twoQueueNode) {
+	if n.frequent {
+		p.frequent.Remove(n.elem)
+	} else {
+		p.recent.Remove(n.elem)
+	}
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// errNegativeCost is returned by Load for a snapshot entry with a negative
+// cost, which SetWithCost would refuse.
+var errNegativeCost = errors.New("cache: snapshot entry has negative cost")
+
+// snapshotEntry is the JSON form of an entry in a snapshot.
+type snapshotEntry[K comparable, V any] struct {
+	Key     K          `json:"key"`
+	Value   V          `json:"value"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Cost    int64      `json:"cost"`
+}
+
+// Snapshot encodes the live entries as JSON, from most to least recently
+// used, along with their expiry times and costs, for Load to restore later.
+// Keys and values are encoded with encoding/json, so it fails if any of them
+// cannot be. It does not change LRU order.
+func (c *TypedCache[K, V]) Snapshot() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	entries := make([]snapshotEntry[K, V], 0, c.size)
+	for e := c.head; e != nil; e = e.next {
+		if e.expired(now) {
+			continue
+		}
+		se := snapshotEntry[K, V]{Key: e.key, Value: e.value, Cost: e.cost}
+		if !e.expires.IsZero() {
+			expires := e.expires
+			se.Expires = &expires
+		}
+		entries = append(entries, se)
+	}
+	return json.Marshal(entries)
+}
+
+// Load replaces the cache's contents with the entries of a Snapshot,
+// restoring their recency order, expiry times and costs. Entries that have
+// expired since, or that cost more than WithMaxCost allows, are skipped, and
+// if there are more than the cache holds the least recently used are evicted
+// as usual. Values are decoded into V by
+// encoding/json, so for a Cache they come back as JSON's
+// default types, such as float64 for numbers. If data cannot be decoded
+// or holds a negative cost Load returns an error and leaves the cache
+// unchanged.
+func (c *TypedCache[K, V]) Load(data []byte) error {
+	var entries []snapshotEntry[K, V]
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	for _, se := range entries {
+		if se.Cost < 0 {
+			return errNegativeCost
+		}
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	c.clear()
+	now := c.clock.Now()
+	// Insert from least to most recently used so the first ends up in front.
+	for i := len(entries) - 1; i >= 0; i-- {
+		se := entries[i]
+		if c.maxCost > 0 && se.Cost > c.maxCost {
+			continue
+		}
+		var ttl time.Duration
+		if se.Expires != nil {
+			if ttl = se.Expires.Sub(now); ttl <= 0 {
+				continue
+			}
+		}
+		c.set(se.Key, se.Value, ttl, se.Cost)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package cache
+
+import "sync/atomic"
+
+// Stats is a point-in-time snapshot of a cache's effectiveness counters.
+type Stats struct {
+	// Hits counts Get calls that found a live entry.
+	Hits int64
+	// Misses counts Get calls that found nothing or an expired entry.
+	Misses int64
+	// Evictions counts entries dropped to keep the cache within capacity.
+	// Expired entries and those deleted by Remove or Clear are not included.
+	Evictions int64
+}
+
+// counters holds the live values behind Stats.
+type counters struct {
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// Stats returns a snapshot of the cache's counters. Each counter is read
+// atomically, but the snapshot as a whole may straddle a concurrent call.
+func (c *TypedCache[K, V]) Stats() Stats {
+	return Stats{
+		Hits:      c.stats.hits.Load(),
+		Misses:    c.stats.misses.Load(),
+		Evictions: c.stats.evictions.Load(),
+	}
+}
@@ -0,0 +1,85 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time for a scheduler. Delays, task timeouts and the
+// durations reported to hooks are all measured with it. The default uses the
+// time package; tests can supply a fake to drive the scheduler without
+// sleeping. Under a fake clock a timed-out task's context reports
+// context.DeadlineExceeded, but contexts the task derives from it report
+// context.Canceled, as the runtime's deadline machinery cannot see the clock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// WithClock makes the scheduler read time from c.
+func WithClock(c Clock) Option {
+	return func(s *Scheduler) { s.clock = c }
+}
+
+// realClock is the default Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// withTimeout is context.WithTimeout driven by clock instead of the runtime's
+// timers. For the default clock it is context.WithTimeout itself. Other
+// clocks get a context whose own Err reports context.DeadlineExceeded once
+// clock expires it, but contexts derived from it only see it cancelled and so
+// report context.Canceled.
+func withTimeout(parent context.Context, clock Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(realClock); ok {
+		return context.WithTimeout(parent, d)
+	}
+	ctx, cancel := context.WithCancel(parent)
+	tc := &timeoutContext{Context: ctx, deadline: clock.Now().Add(d), cancel: cancel}
+	if pd, ok := parent.Deadline(); ok && pd.Before(tc.deadline) {
+		tc.deadline = pd
+	}
+	timer := clock.After(d)
+	go func() {
+		select {
+		case <-timer:
+			tc.expire()
+		case <-ctx.Done():
+		}
+	}()
+	return tc, cancel
+}
+
+// timeoutContext reports context.DeadlineExceeded once its clock expires it.
+type timeoutContext struct {
+	context.Context
+	deadline time.Time
+	cancel   context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+func (c *timeoutContext) Deadline() (time.Time, bool) { return c.deadline, true }
+
+func (c *timeoutContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return c.Context.Err()
+}
+
+func (c *timeoutContext) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Context.Err() == nil {
+		c.err = context.DeadlineExceeded
+	}
+	c.cancel()
+}
@@ -0,0 +1,105 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrCycle is reported when task dependencies form a cycle.
+	ErrCycle = errors.New("scheduler: dependency cycle")
+	// ErrDependencyFailed is reported for a task skipped because one of its
+	// dependencies did not succeed.
+	ErrDependencyFailed = errors.New("scheduler: dependency failed")
+	// ErrSkipped is reported by a batch run for a task that never started,
+	// wrapped around the reason: ErrDependencyFailed, or the context error
+	// when the run was cancelled or timed out first.
+	ErrSkipped = errors.New("scheduler: task skipped")
+	// ErrInvalidTask is reported for a task whose fields are out of range,
+	// such as a negative Weight or Timeout.
+	ErrInvalidTask = errors.New("scheduler: invalid task")
+)
+
+// Validate checks tasks the way Run does before starting any of them, and
+// returns the first problem found, naming the offending task's index: a
+// dependency on an unknown task, a dependency cycle (wrapping ErrCycle), or a
+// negative Weight or Timeout (wrapping ErrInvalidTask). Nothing is run.
+func (s *Scheduler) Validate(tasks []Task) error {
+	_, err := validate(tasks)
+	return err
+}
+
+// validate checks tasks and returns their dependency graph; see Validate.
+func validate(tasks []Task) ([][]int, error) {
+	for i, task := range tasks {
+		if err := task.check(); err != nil {
+			return nil, fmt.Errorf("%w: task %d %s", ErrInvalidTask, i, err)
+		}
+	}
+	return dependencyGraph(tasks)
+}
+
+// check reports a field of t that is out of range, without an index so that
+// Submit and validate can each describe the task their own way.
+func (t Task) check() error {
+	switch {
+	case t.Weight < 0:
+		return fmt.Errorf("has negative Weight %d", t.Weight)
+	case t.Timeout < 0:
+		return fmt.Errorf("has negative Timeout %v", t.Timeout)
+	}
+	return nil
+}
+
+// dependencyGraph returns, for each task, the indices of the tasks that depend
+// on it. It reports an error if a dependency index is out of range or the
+// dependencies form a cycle.
+func dependencyGraph(tasks []Task) ([][]int, error) {
+	dependents := make([][]int, len(tasks))
+	indegree := make([]int, len(tasks))
+	for i, task := range tasks {
+		for _, dep := range task.DependsOn {
+			if dep < 0 || dep >= len(tasks) {
+				return nil, fmt.Errorf("scheduler: task %d depends on unknown task %d", i, dep)
+			}
+			dependents[dep] = append(dependents[dep], i)
+			indegree[i]++
+		}
+	}
+
+	// Kahn's algorithm: anything left with unmet dependencies is on or behind
+	// a cycle.
+	var ready []int
+	for i, n := range indegree {
+		if n == 0 {
+			ready = append(ready, i)
+		}
+	}
+	for len(ready) > 0 {
+		i := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		for _, d := range dependents[i] {
+			if indegree[d]--; indegree[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	for i := range tasks {
+		if indegree[i] == 0 {
+			continue
+		}
+		// Every remaining task has a remaining dependency, so following them
+		// len(tasks) times is guaranteed to land on the cycle itself.
+		for range tasks {
+			for _, dep := range tasks[i].DependsOn {
+				if indegree[dep] > 0 {
+					i = dep
+					break
+				}
+			}
+		}
+		return nil, fmt.Errorf("%w through task %d", ErrCycle, i)
+	}
+	return dependents, nil
+}
@@ -0,0 +1,112 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// RunGroup returns a pair of functions that behave like an errgroup.Group
+// from golang.org/x/sync/errgroup created by WithContext, except that tasks
+// go through the scheduler's worker pool: they are dispatched by priority
+// within the limit shared with every other submitted task. submit queues a
+// task, and wait blocks until every task submitted to the group has finished
+// and returns the first error one of them returned, if any.
+//
+// Each task runs under a context derived from ctx, so it sees ctx's values
+// and deadline along with those set by Task.WithValue, and that is also
+// cancelled when the pool's own context for the task is, for example by its
+// Timeout or by Close. The first error cancels the context the group derives
+// from ctx, as does ctx being done: the group's running tasks see their
+// contexts cancelled, and its queued ones are discarded with
+// context.Canceled. wait cancels it too once
+// it returns. A task that Submit rejects, for example with ErrClosed, counts
+// as the group's failure. Group tasks are ordinary pool tasks, so Wait and
+// Drain see their errors as well.
+//
+// submit may be called from multiple goroutines, including from within the
+// group's tasks; like errgroup, calls made after wait has returned are not
+// waited for.
+func (s *Scheduler) RunGroup(ctx context.Context) (submit func(Task), wait func() error) {
+	ctx, cancel := context.WithCancel(ctx)
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			first = err
+			cancel()
+		})
+	}
+	submit = func(task Task) {
+		fn := task.Fn
+		task.Fn = func(taskCtx context.Context) error {
+			// Failing here, before the worker can start another task, keeps
+			// queued tasks from slipping past the first error.
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			runCtx, stop := s.groupContext(ctx, taskCtx, task.values)
+			err := fn(runCtx)
+			stop()
+			if err != nil {
+				fail(err)
+			}
+			return err
+		}
+		h, err := s.Submit(task)
+		if err != nil {
+			fail(err)
+			return
+		}
+		stop := context.AfterFunc(ctx, h.Cancel)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-h.Done()
+			stop()
+			if err := h.Err(); err != nil {
+				fail(err)
+			}
+		}()
+	}
+	wait = func() error {
+		wg.Wait()
+		cancel()
+		return first
+	}
+	return submit, wait
+}
+
+// groupContext returns the context a group task runs under: one derived from
+// the group's ctx, carrying the task's values, that is also done when
+// taskCtx, the context the pool runs it under, is. Under the default clock a
+// Timeout on taskCtx carries over as a deadline, so the task still sees
+// context.DeadlineExceeded. Call stop once the task has returned.
+func (s *Scheduler) groupContext(ctx, taskCtx context.Context, values []taskValue) (runCtx context.Context, stop func()) {
+	var cancel context.CancelFunc
+	d, ok := taskCtx.Deadline()
+	_, realTime := s.clock.(realClock)
+	inherit := ok && realTime
+	if inherit {
+		ctx, cancel = context.WithDeadline(ctx, d)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	unhook := context.AfterFunc(taskCtx, func() {
+		// Leave an expired deadline to ctx's own timer, which reports it as
+		// DeadlineExceeded rather than Canceled.
+		if !inherit || !errors.Is(taskCtx.Err(), context.DeadlineExceeded) {
+			cancel()
+		}
+	})
+	for _, v := range values {
+		ctx = context.WithValue(ctx, v.key, v.value)
+	}
+	return ctx, func() {
+		unhook()
+		cancel()
+	}
+}
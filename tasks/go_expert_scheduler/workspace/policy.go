@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"container/heap"
+	"slices"
+)
+
+// Policy decides which ready task a scheduler starts next.
+type Policy int
+
+const (
+	// PolicyPriority always starts the highest-priority ready task, in input
+	// or submission order among equals. It is the default.
+	PolicyPriority Policy = iota
+	// PolicyFairShare takes turns between the Task.Group values that have
+	// ready tasks, starting the highest-priority task of each group in
+	// rotation so that no group can monopolise the limit. A group that runs
+	// out of ready tasks leaves the rotation and rejoins at the back when
+	// more arrive.
+	PolicyFairShare
+	// PolicyFIFO starts ready tasks strictly in input or submission order,
+	// ignoring Priority, WithAging and WithLess, for callers that relied on
+	// tasks running in the order given. Tasks still wait for the limit, any
+	// caps and their NotBefore and dependencies.
+	PolicyFIFO
+)
+
+// WithPolicy selects the dispatch policy for both Run and Submit.
+func WithPolicy(p Policy) Option {
+	return func(s *Scheduler) { s.policy = p }
+}
+
+// readyQueue holds tasks that are ready to start, ordered by the scheduler's
+// policy. Under PolicyPriority every task shares one heap; under
+// PolicyFairShare each group has its own heap and groups are served in turn.
+type readyQueue struct {
+	fair   bool
+	groups map[string]*taskHeap
+	// rotation lists the groups with queued tasks; the first is served next.
+	rotation []string
+	n        int
+}
+
+func (s *Scheduler) newQueue() readyQueue {
+	return readyQueue{fair: s.policy == PolicyFairShare}
+}
+
+func (q *readyQueue) Len() int { return q.n }
+
+func (q *readyQueue) group(it *item) string {
+	if q.fair {
+		return it.task.Group
+	}
+	return ""
+}
+
+func (q *readyQueue) push(it *item) {
+	g := q.group(it)
+	h := q.groups[g]
+	if h == nil {
+		if q.groups == nil {
+			q.groups = make(map[string]*taskHeap)
+		}
+		h = new(taskHeap)
+		q.groups[g] = h
+		q.rotation = append(q.rotation, g)
+	}
+	heap.Push(h, it)
+	q.n++
+}
+
+// peek returns the task pop would return next. The queue must not be empty.
+func (q *readyQueue) peek() *item {
+	return (*q.groups[q.rotation[0]])[0]
+}
+
+// pop removes and returns the next task, moving its group to the back of the
+// rotation. The queue must not be empty.
+func (q *readyQueue) pop() *item {
+	g := q.rotation[0]
+	h := q.groups[g]
+	it := heap.Pop(h).(*item)
+	q.n--
+	q.rotation = q.rotation[1:]
+	if h.Len() > 0 {
+		q.rotation = append(q.rotation, g)
+	} else {
+		delete(q.groups, g)
+	}
+	return it
+}
+
+// remove takes it out of the queue, reporting whether it was queued.
+func (q *readyQueue) remove(it *item) bool {
+	g := q.group(it)
+	h := q.groups[g]
+	if h == nil {
+		return false
+	}
+	i := slices.Index(*h, it)
+	if i < 0 {
+		return false
+	}
+	heap.Remove(h, i)
+	q.n--
+	if h.Len() == 0 {
+		delete(q.groups, g)
+		q.rotation = slices.DeleteFunc(q.rotation, func(other string) bool { return other == g })
+	}
+	return true
+}
+
+// clear empties the queue and returns the tasks it held, in no particular
+// order.
+func (q *readyQueue) clear() []*item {
+	var items []*item
+	for _, h := range q.groups {
+		items = append(items, *h...)
+	}
+	q.groups, q.rotation, q.n = nil, nil, 0
+	return items
+}
+
+// rank returns how many queued tasks pop would return before it, or -1 if
+// it is not queued.
+func (q *readyQueue) rank(it *item) int {
+	h := q.groups[q.group(it)]
+	if h == nil {
+		return -1
+	}
+	found := false
+	ahead := 0
+	for _, other := range *h {
+		if other == it {
+			found = true
+		} else if before(other, it) {
+			ahead++
+		}
+	}
+	if !found {
+		return -1
+	}
+	if !q.fair {
+		return ahead
+	}
+	// Groups take one turn each per round; the task is reached in round
+	// ahead of its group, after the groups before it in the rotation.
+	turn := slices.Index(q.rotation, q.group(it))
+	n := 0
+	for i, g := range q.rotation {
+		rounds := ahead
+		if i < turn {
+			rounds++
+		}
+		n += min(q.groups[g].Len(), rounds)
+	}
+	return n
+}
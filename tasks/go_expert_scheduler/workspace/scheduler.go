@@ -1,29 +1,769 @@
 package scheduler
 
-import "context"
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
 
 // Task represents a unit of work with a priority.
 type Task struct {
 	Priority int
 	Fn       func(context.Context) error
+	// ValueFn is called instead of Fn when set; its value is reported by
+	// RunWithResults.
+	ValueFn func(context.Context) (any, error)
+	// Timeout bounds how long the task may run when positive; negative
+	// timeouts are rejected. A task that is still running when it expires
+	// has its context cancelled and reports context.DeadlineExceeded; other
+	// tasks are unaffected.
+	Timeout time.Duration
+	// DependsOn lists the indices of tasks that must succeed before this one
+	// may start. If any of them fails the task is skipped and reports an
+	// error wrapping ErrSkipped and ErrDependencyFailed.
+	DependsOn []int
+	// NotBefore holds the task back until the scheduler's clock reaches it.
+	// Other eligible tasks keep running in the meantime, and delayed tasks
+	// that become ready together still start in priority order.
+	NotBefore time.Time
+	// Weight is how much of the scheduler's limit the task occupies while
+	// running; zero counts as 1 and negative weights are rejected. See New.
+	Weight int
+	// Group names the tenant or class the task belongs to. It only affects
+	// dispatch under PolicyFairShare.
+	Group string
+
+	values []taskValue // added by WithValue, oldest first
+}
+
+// taskValue is a key-value pair set on a task's context.
+type taskValue struct {
+	key, value any
+}
+
+// WithValue returns a copy of t whose function sees value for key in its
+// context, as if set by context.WithValue on top of the context the
+// scheduler passes in, for carrying metadata such as a request ID or tenant.
+// The value is seen by this task alone, and t itself is unchanged, so one
+// Task may serve as a template for several. Later calls with an equal key
+// shadow earlier ones. Like context.WithValue it panics if key is nil or not
+// comparable.
+func (t Task) WithValue(key, value any) Task {
+	if key == nil {
+		panic("scheduler: nil context key")
+	}
+	if !reflect.TypeOf(key).Comparable() {
+		panic("scheduler: context key is not comparable")
+	}
+	t.values = append(slices.Clip(t.values), taskValue{key, value})
+	return t
+}
+
+// weight returns the share of the limit the task occupies.
+func (t Task) weight() int {
+	if t.Weight <= 0 {
+		return 1
+	}
+	return t.Weight
+}
+
+// fits reports whether a task of weight w may start while inUse of limit is
+// taken. A task heavier than the whole limit starts once nothing else is
+// running rather than never.
+func fits(inUse, w, limit int) bool {
+	return inUse+w <= limit || inUse == 0
+}
+
+// call invokes the task's function with the values set by WithValue and under
+// its own timeout, if any, measured by clock.
+func (t Task) call(ctx context.Context, clock Clock) (any, error) {
+	for _, v := range t.values {
+		ctx = context.WithValue(ctx, v.key, v.value)
+	}
+	if t.Timeout <= 0 {
+		return t.invoke(ctx)
+	}
+	tctx, cancel := withTimeout(ctx, clock, t.Timeout)
+	defer cancel()
+	value, err := t.invoke(tctx)
+	if err == nil && ctx.Err() == nil && tctx.Err() != nil {
+		err = tctx.Err()
+	}
+	return value, err
+}
+
+// invoke runs the task's function, converting a panic into a *PanicError.
+func (t Task) invoke(ctx context.Context) (value any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			value, err = nil, &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	if t.ValueFn != nil {
+		return t.ValueFn(ctx)
+	}
+	return nil, t.Fn(ctx)
 }
 
-// Scheduler executes tasks with a concurrency limit.
+// PanicError is reported for a task whose function panicked.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("scheduler: task panicked: %v\n%s", e.Value, e.Stack)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Result is the outcome of a single task.
+type Result struct {
+	// Index is the task's position in the slice passed to Run.
+	Index int
+	Value any
+	Err   error
+	// Duration is how long the task's function ran, measured by the
+	// scheduler's clock. With WithQueueWaitAccounting it also includes the
+	// time the task spent ready but waiting to start. It is zero for tasks
+	// that never started.
+	Duration time.Duration
+}
+
+// Scheduler executes tasks with a concurrency limit, either in batches via Run
+// or continuously via Submit.
 type Scheduler struct {
-	limit int
+	limit        int           // guarded by mu
+	limitChanged chan struct{} // closed and replaced by SetLimit
+	clock        Clock
+
+	onStart    func(Task)
+	onComplete func(Task, error, time.Duration)
+	onError    func(Task, error, time.Duration)
+
+	agingRate       int
+	agingPer        time.Duration
+	continueOnError bool
+	drainOnError    bool
+	limiter         RateLimiter
+	limiterPoll     time.Duration
+	countQueueWait  bool
+	timeout         time.Duration
+	policy          Policy
+	less            func(a, b Task) bool
+	caps            map[int]int // per-priority concurrency caps
+
+	stats counters
+
+	// The fields below back the long-lived worker pool used by Submit.
+	mu         sync.Mutex
+	work       *sync.Cond // signalled when tasks are queued or the pool closes
+	idle       *sync.Cond // broadcast when unfinished drops to zero
+	queue      readyQueue
+	delayed    delayHeap
+	wakeAt     time.Time // when the earliest armed delay timer fires
+	submitted  int
+	unfinished int
+	workers    int
+	inUse      int           // total weight of the pool's running tasks
+	byPriority map[int]int   // the pool's running tasks per priority
+	avgRun     time.Duration // moving average of the pool's task durations
+	runs       int           // tasks folded into avgRun
+	throttled  bool          // the rate limiter refused and a retry timer is armed
+	errs       []error
+	started    bool
+	draining   bool
+	closed     bool
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// Option configures a Scheduler.
+type Option func(*Scheduler)
+
+// WithOnStart registers a hook called as each task starts.
+func WithOnStart(fn func(Task)) Option {
+	return func(s *Scheduler) { s.onStart = fn }
 }
 
-// New creates a scheduler with the provided concurrency limit.
-func New(limit int) *Scheduler {
-	return &Scheduler{limit: limit}
+// WithOnComplete registers a hook called after each task returns, with its
+// error (nil on success) and how long it ran.
+func WithOnComplete(fn func(Task, error, time.Duration)) Option {
+	return func(s *Scheduler) { s.onComplete = fn }
+}
+
+// WithOnError registers a hook called after each task that fails, with its
+// error and how long it ran.
+func WithOnError(fn func(Task, error, time.Duration)) Option {
+	return func(s *Scheduler) { s.onError = fn }
+}
+
+// WithAging lets waiting tasks gain rate priority for every per they spend
+// queued, so a steady stream of high-priority work cannot starve older
+// low-priority tasks forever. Without it ordering is strictly by Priority. It
+// panics if per is not positive.
+func WithAging(rate int, per time.Duration) Option {
+	if per <= 0 {
+		panic("scheduler: aging period must be positive")
+	}
+	return func(s *Scheduler) { s.agingRate, s.agingPer = rate, per }
 }
 
-// Run executes tasks in FIFO order ignoring priority.
+// RateLimiter grants permission to start tasks. Allow reports whether tokens
+// may be spent at the given time, consuming them if so. The TokenBucket in this
+// repository's tokenbucket package satisfies it.
+type RateLimiter interface {
+	Allow(at time.Time, tokens int) bool
+}
+
+// WithLess orders ready tasks by less instead of by Priority: a task for
+// which less(a, b) reports true starts before b, and tasks neither of which
+// is less than the other start in input or submission order. This allows
+// orderings such as earliest deadline first without a field per policy.
+// Priority and WithAging are ignored while it is set; under PolicyFairShare
+// it orders tasks within each group. less must be a strict weak ordering and
+// must not block, since it is called with the scheduler's lock held.
+func WithLess(less func(a, b Task) bool) Option {
+	return func(s *Scheduler) { s.less = less }
+}
+
+// WithPriorityCap limits tasks of the given Priority to maxConcurrent running
+// at once, within the scheduler's overall limit, so that for example
+// background work cannot fill the pool. Priorities without a cap may use the
+// whole limit. Caps are per Priority value and apply to Run and Submit
+// separately, like the limit. As with a task too heavy to fit, a capped task
+// at the head of the queue holds back the tasks behind it until one of its
+// priority finishes. It panics if maxConcurrent is not positive.
+func WithPriorityCap(priority, maxConcurrent int) Option {
+	if maxConcurrent <= 0 {
+		panic("scheduler: priority cap must be positive")
+	}
+	return func(s *Scheduler) {
+		if s.caps == nil {
+			s.caps = make(map[int]int)
+		}
+		s.caps[priority] = maxConcurrent
+	}
+}
+
+// startable reports whether task may start now that inUse of limit is taken
+// and byPriority of each priority are running.
+func (s *Scheduler) startable(task Task, inUse, limit int, byPriority map[int]int) bool {
+	return fits(inUse, task.weight(), limit) && !s.capped(byPriority, task)
+}
+
+// capped reports whether task's priority is at its cap, given how many tasks
+// of each priority are running.
+func (s *Scheduler) capped(running map[int]int, task Task) bool {
+	limit, ok := s.caps[task.Priority]
+	return ok && running[task.Priority] >= limit
+}
+
+// WithRateLimiter makes the scheduler take one token from l before starting
+// each task, on top of the concurrency limit. When l refuses, dispatch pauses
+// and asks again every poll on the scheduler's clock; queued tasks keep their
+// order meanwhile and a cancelled context still stops the wait. It panics if
+// poll is not positive.
+func WithRateLimiter(l RateLimiter, poll time.Duration) Option {
+	if poll <= 0 {
+		panic("scheduler: rate limiter poll interval must be positive")
+	}
+	return func(s *Scheduler) { s.limiter, s.limiterPoll = l, poll }
+}
+
+// permit asks the rate limiter, if any, for leave to start a task.
+func (s *Scheduler) permit() bool {
+	return s.limiter == nil || s.limiter.Allow(s.clock.Now(), 1)
+}
+
+// WithQueueWaitAccounting makes Result.Duration start when a task becomes
+// ready to run rather than when its function is invoked, so it includes time
+// spent queued behind other work. Time held back by NotBefore or unfinished
+// dependencies is not counted.
+func WithQueueWaitAccounting() Option {
+	return func(s *Scheduler) { s.countQueueWait = true }
+}
+
+// WithTimeout bounds each Run, RunAll, RunWithResults and RunStream call to d
+// on the scheduler's clock, on top of any deadline the caller's context
+// already carries; whichever comes first wins. When it expires running tasks
+// see their context cancelled, no further tasks start and the run reports
+// context.DeadlineExceeded. It does not apply to Submit. It panics if d is not
+// positive.
+func WithTimeout(d time.Duration) Option {
+	if d <= 0 {
+		panic("scheduler: timeout must be positive")
+	}
+	return func(s *Scheduler) { s.timeout = d }
+}
+
+// WithContinueOnError makes Run keep dispatching after a task fails instead of
+// stopping at the first error. Run then returns once every task has settled,
+// reporting the failure with the lowest input index so the result does not
+// depend on completion order.
+func WithContinueOnError() Option {
+	return func(s *Scheduler) { s.continueOnError = true }
+}
+
+// WithDrainOnError makes Run, when it stops at the first error or because
+// ctx is done, wait for the tasks already running to return before it does,
+// so that none of them outlives the call. No further tasks start meanwhile,
+// and running tasks' contexts are left alone on a task error so they can
+// finish their work. Run still returns the original error.
+func WithDrainOnError() Option {
+	return func(s *Scheduler) { s.drainOnError = true }
+}
+
+// New creates a scheduler with the provided concurrency limit. The limit is a
+// budget shared by running tasks according to their Weight, so with the
+// default weight of 1 it is simply the number of tasks that may run at once.
+// When the highest-ranked waiting task does not fit, lighter tasks behind it
+// wait too rather than jumping ahead. It panics if limit is not positive.
+func New(limit int, opts ...Option) *Scheduler {
+	if limit <= 0 {
+		panic("scheduler: limit must be positive")
+	}
+	s := &Scheduler{limit: limit, limitChanged: make(chan struct{}), clock: realClock{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.queue = s.newQueue()
+	s.byPriority = make(map[int]int)
+	s.work = sync.NewCond(&s.mu)
+	s.idle = sync.NewCond(&s.mu)
+	return s
+}
+
+// SetLimit changes the concurrency limit for future dispatch, both for the
+// Submit pool and for Run calls in progress. Raising it starts waiting tasks
+// straight away; lowering it lets running tasks finish but starts no more
+// until fewer than n are running. It panics if n is not positive, like New.
+func (s *Scheduler) SetLimit(n int) {
+	if n <= 0 {
+		panic("scheduler: limit must be positive")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = n
+	close(s.limitChanged)
+	s.limitChanged = make(chan struct{})
+	if s.started && !s.closed {
+		for ; s.workers < n; s.workers++ {
+			go s.worker()
+		}
+	}
+	s.work.Broadcast()
+}
+
+// currentLimit returns the concurrency limit and a channel that is closed
+// when it next changes.
+func (s *Scheduler) currentLimit() (int, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit, s.limitChanged
+}
+
+// execute runs a dispatched item, firing the lifecycle hooks around it. Hooks
+// run on the task's goroutine and never under the scheduler's lock, so a
+// slow hook delays only its own task. The result's Index is left for the
+// caller to fill in.
+func (s *Scheduler) execute(ctx context.Context, it *item) Result {
+	task := it.task
+	s.stats.running.Add(1)
+	if s.onStart != nil {
+		s.onStart(task)
+	}
+	start := s.clock.Now()
+	value, err := task.call(ctx, s.clock)
+	elapsed := s.clock.Now().Sub(start)
+	s.stats.running.Add(-1)
+	if err != nil {
+		s.stats.failed.Add(1)
+	} else {
+		s.stats.completed.Add(1)
+	}
+	if s.onComplete != nil {
+		s.onComplete(task, err, elapsed)
+	}
+	if err != nil && s.onError != nil {
+		s.onError(task, err, elapsed)
+	}
+	if s.countQueueWait {
+		elapsed += start.Sub(it.readyAt)
+	}
+	return Result{Value: value, Err: err, Duration: elapsed}
+}
+
+// Run executes tasks concurrently, never running more than the scheduler's
+// limit at once. Whenever a slot frees up the highest-priority task whose
+// dependencies have completed is started next; tasks sharing a priority start
+// in input order. If the tasks fail Validate, for example because their
+// dependencies form a cycle, Run returns that error without running anything.
+//
+// Run returns the first task error, or ctx.Err() if the context is cancelled.
+// In either case no further tasks are started and the context passed to
+// running tasks is cancelled, unless WithDrainOnError is set, in which case Run
+// first waits for them to return. See WithContinueOnError for running every
+// task regardless, and WithPolicy for sharing the limit fairly between groups.
 func (s *Scheduler) Run(ctx context.Context, tasks []Task) error {
-	for _, task := range tasks {
-		if err := task.Fn(ctx); err != nil {
-			return err
+	if !s.continueOnError {
+		_, err := s.run(ctx, tasks, true, nil)
+		return err
+	}
+	results, err := s.run(ctx, tasks, false, nil)
+	for _, r := range results {
+		if r.Err != nil {
+			return r.Err
+		}
+	}
+	return err
+}
+
+// RunAll executes every task regardless of individual failures and returns
+// their errors aligned with tasks, holding nil for tasks that succeeded. It
+// honours the same limit and ordering as Run. If ctx is cancelled, RunAll stops
+// starting tasks, waits for running ones to return and reports ErrSkipped
+// wrapping ctx.Err() for the tasks that never started. If the tasks are
+// invalid every task reports the validation error.
+func (s *Scheduler) RunAll(ctx context.Context, tasks []Task) []error {
+	results, err := s.run(ctx, tasks, false, nil)
+	if results == nil {
+		errs := make([]error, len(tasks))
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	return resultErrors(results)
+}
+
+// RunWithResults executes every task like RunAll and returns their results
+// positioned by input index, regardless of completion order. The error joins
+// all task failures as JoinErrors does. If the tasks are invalid it
+// returns no results and the validation error.
+func (s *Scheduler) RunWithResults(ctx context.Context, tasks []Task) ([]Result, error) {
+	results, err := s.run(ctx, tasks, false, nil)
+	if results == nil {
+		return nil, err
+	}
+	return results, JoinErrors(resultErrors(results))
+}
+
+// RunStream executes tasks like RunAll and yields each task's result on the
+// returned channel as soon as it is known, in completion order rather than
+// input order; use Result.Index to match them up. Skipped and unstarted tasks
+// are reported too, so exactly len(tasks) results are sent before the channel
+// is closed. The channel is buffered to hold every result, so a caller that
+// stops reading, for example after cancelling ctx, does not leak the
+// scheduler's goroutines.
+func (s *Scheduler) RunStream(ctx context.Context, tasks []Task) <-chan Result {
+	ch := make(chan Result, len(tasks))
+	go func() {
+		defer close(ch)
+		results, err := s.run(ctx, tasks, false, func(r Result) { ch <- r })
+		if results == nil {
+			for i := range tasks {
+				ch <- Result{Index: i, Err: err}
+			}
+		}
+	}()
+	return ch
+}
+
+// JoinErrors combines the non-nil errors returned by RunAll into a single
+// error, returning nil when every task succeeded.
+func JoinErrors(errs []error) error {
+	return errors.Join(errs...)
+}
+
+// resultErrors extracts the error of each result.
+func resultErrors(results []Result) []error {
+	errs := make([]error, len(results))
+	for i, r := range results {
+		errs[i] = r.Err
+	}
+	return errs
+}
+
+// run dispatches tasks in priority order as their dependencies complete and
+// records their results by index. With failFast set it returns as soon as a
+// task fails or ctx is done, leaving running tasks to observe the cancelled
+// context. Otherwise it keeps going past failures, skipping the dependents of
+// failed tasks, and on cancellation waits for running tasks before returning.
+// Invalid dependencies are reported with nil results. If emit is not nil it is
+// called with each result as it is recorded.
+func (s *Scheduler) run(ctx context.Context, tasks []Task, failFast bool, emit func(Result)) ([]Result, error) {
+	dependents, err := validate(tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if s.timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = withTimeout(ctx, s.clock, s.timeout)
+		defer stop()
+	}
+
+	// Tasks still counted as queued when run returns will never start.
+	started := 0
+	s.stats.submitted.Add(int64(len(tasks)))
+	s.stats.queued.Add(int64(len(tasks)))
+	defer func() { s.stats.queued.Add(int64(started - len(tasks))) }()
+
+	// Ready tasks wait in pending, or in delayed until their NotBefore.
+	pending := s.newQueue()
+	var delayed delayHeap
+	enqueue := func(it *item) {
+		if it.task.NotBefore.After(s.clock.Now()) {
+			heap.Push(&delayed, it)
+		} else {
+			pending.push(it)
+		}
+	}
+	// blocked counts the dependencies each task is still waiting on.
+	blocked := make([]int, len(tasks))
+	for i, task := range tasks {
+		blocked[i] = len(task.DependsOn)
+		if blocked[i] == 0 {
+			enqueue(s.newItem(task, i))
+		}
+	}
+
+	results := make([]Result, len(tasks))
+	settled := make([]bool, len(tasks))
+	unsettled := len(tasks)
+	settle := func(index int, r Result) {
+		r.Index = index
+		results[index] = r
+		if emit != nil {
+			emit(r)
+		}
+		settled[index] = true
+		unsettled--
+	}
+	var skip func(index int)
+	skip = func(index int) {
+		for _, d := range dependents[index] {
+			if !settled[d] {
+				err := fmt.Errorf("%w: %w: task %d: %w", ErrSkipped, ErrDependencyFailed, index, results[index].Err)
+				settle(d, Result{Err: err})
+				skip(d)
+			}
+		}
+	}
+
+	// Buffered so that tasks still running after run returns never block.
+	done := make(chan Result, len(tasks))
+	running, inUse := 0, 0
+	byPriority := make(map[int]int)
+	// drain waits for the running tasks and records their results.
+	drain := func() {
+		for ; running > 0; running-- {
+			out := <-done
+			settle(out.Index, out)
 		}
 	}
-	return nil
+	var wake, throttled <-chan time.Time
+	var wakeAt time.Time
+	for unsettled > 0 {
+		limit, limitChanged := s.currentLimit()
+		delayed.promote(s.clock.Now(), &pending)
+		if delayed.Len() > 0 && (wake == nil || delayed[0].task.NotBefore.Before(wakeAt)) {
+			wakeAt = delayed[0].task.NotBefore
+			wake = s.clock.After(wakeAt.Sub(s.clock.Now()))
+		}
+
+		for throttled == nil && pending.Len() > 0 && ctx.Err() == nil && s.startable(pending.peek().task, inUse, limit, byPriority) {
+			if !s.permit() {
+				throttled = s.clock.After(s.limiterPoll)
+				break
+			}
+			it := pending.pop()
+			running++
+			inUse += it.task.weight()
+			byPriority[it.task.Priority]++
+			started++
+			s.stats.queued.Add(-1)
+			go func() {
+				r := s.execute(ctx, it)
+				r.Index = it.index
+				done <- r
+			}()
+		}
+
+		select {
+		case out := <-done:
+			running--
+			inUse -= tasks[out.Index].weight()
+			byPriority[tasks[out.Index].Priority]--
+			settle(out.Index, out)
+			if out.Err != nil {
+				if failFast {
+					if s.drainOnError {
+						drain()
+					}
+					return results, out.Err
+				}
+				skip(out.Index)
+				continue
+			}
+			for _, d := range dependents[out.Index] {
+				if blocked[d]--; blocked[d] == 0 && !settled[d] {
+					enqueue(s.newItem(tasks[d], d))
+				}
+			}
+		case <-wake:
+			wake = nil
+		case <-throttled:
+			throttled = nil
+		case <-limitChanged:
+		case <-ctx.Done():
+			if failFast {
+				if s.drainOnError {
+					drain()
+				}
+				return results, ctx.Err()
+			}
+			drain()
+			for i := range results {
+				if !settled[i] {
+					settle(i, Result{Err: fmt.Errorf("%w: %w", ErrSkipped, ctx.Err())})
+				}
+			}
+			return results, ctx.Err()
+		}
+	}
+	return results, nil
+}
+
+// item is a task waiting in the queue alongside its position in the input.
+type item struct {
+	task  Task
+	index int
+	// score ranks the item in a taskHeap: its priority, less any aging
+	// credit (see newItem).
+	score float64
+	// less, when set, ranks the item in place of score; see WithLess.
+	less func(a, b Task) bool
+	// readyAt is when the task became eligible to run.
+	readyAt time.Time
+	// handle is set for tasks queued with Submit.
+	handle *Handle
+}
+
+// newItem wraps task for queueing. With aging enabled a task's effective
+// priority at time t is Priority + rate*(t-ready)/per, where ready is when it
+// became eligible to run. Comparing two tasks at the same t cancels the t
+// term, so scoring by Priority - rate*ready/per orders the heap correctly no
+// matter how much time passes.
+func (s *Scheduler) newItem(task Task, index int) *item {
+	ready := s.clock.Now()
+	if task.NotBefore.After(ready) {
+		ready = task.NotBefore
+	}
+	it := &item{task: task, index: index, readyAt: ready}
+	if s.policy == PolicyFIFO {
+		// Equal scores leave the heap ordered by index alone.
+		return it
+	}
+	it.score, it.less = float64(task.Priority), s.less
+	if s.agingRate != 0 {
+		it.score -= float64(s.agingRate) * float64(ready.UnixNano()) / float64(s.agingPer)
+	}
+	return it
+}
+
+// taskHeap is a max-heap of items ordered by score, or by the WithLess
+// comparator, breaking ties by input index so equal priorities dispatch FIFO.
+type taskHeap []*item
+
+func (h taskHeap) Len() int { return len(h) }
+
+func (h taskHeap) Less(i, j int) bool { return before(h[i], h[j]) }
+
+// before reports whether a is dispatched ahead of b from the same heap.
+func before(a, b *item) bool {
+	if a.less != nil {
+		if a.less(a.task, b.task) {
+			return true
+		}
+		if a.less(b.task, a.task) {
+			return false
+		}
+	} else if a.score != b.score {
+		return a.score > b.score
+	}
+	return a.index < b.index
+}
+
+func (h taskHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *taskHeap) Push(x any) { *h = append(*h, x.(*item)) }
+
+func (h *taskHeap) Pop() any {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return it
+}
+
+// delayHeap is a min-heap of items ordered by NotBefore.
+type delayHeap []*item
+
+func (h delayHeap) Len() int { return len(h) }
+
+func (h delayHeap) Less(i, j int) bool {
+	if !h[i].task.NotBefore.Equal(h[j].task.NotBefore) {
+		return h[i].task.NotBefore.Before(h[j].task.NotBefore)
+	}
+	return h[i].index < h[j].index
+}
+
+func (h delayHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *delayHeap) Push(x any) { *h = append(*h, x.(*item)) }
+
+func (h *delayHeap) Pop() any {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return it
+}
+
+// remove takes it out of the heap, reporting whether it was there.
+func (h *delayHeap) remove(it *item) bool {
+	i := slices.Index(*h, it)
+	if i < 0 {
+		return false
+	}
+	heap.Remove(h, i)
+	return true
+}
+
+// promote moves every item due at now onto ready and returns how many moved.
+func (h *delayHeap) promote(now time.Time, ready *readyQueue) int {
+	n := 0
+	for h.Len() > 0 && !(*h)[0].task.NotBefore.After(now) {
+		ready.push(heap.Pop(h).(*item))
+		n++
+	}
+	return n
 }
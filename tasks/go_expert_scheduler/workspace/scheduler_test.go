@@ -3,8 +3,10 @@ package scheduler
 import (
     "context"
     "errors"
-    "sort"
+    "slices"
+    "strings"
     "sync"
+    "sync/atomic"
     "testing"
     "time"
 )
@@ -28,6 +30,7 @@ func TestRespectPriorityAndConcurrency(t *testing.T) {
             Fn: func(ctx context.Context) error {
                 defer wg.Done()
                 mu.Lock()
+                order = append(order, priority)
                 concurrency++
                 if concurrency > maxConcurrency {
                     maxConcurrency = concurrency
@@ -41,7 +44,6 @@ func TestRespectPriorityAndConcurrency(t *testing.T) {
                 }
 
                 mu.Lock()
-                order = append(order, priority)
                 concurrency--
                 mu.Unlock()
                 return nil
@@ -59,18 +61,20 @@ func TestRespectPriorityAndConcurrency(t *testing.T) {
         t.Fatalf("expected concurrency limit 2, got %d", maxConcurrency)
     }
 
-    expected := make([]int, len(tasks))
-    for i := range expected {
-        expected[i] = 5 - i
-    }
-    if !sort.IntsAreSorted(order) {
-        t.Fatalf("tasks should be executed by descending priority: %v", order)
+    // Tasks started within the same wave of free slots may record their start
+    // in either order, but anything started a full wave later must rank lower.
+    for i := range order {
+        for j := i + 2; j < len(order); j++ {
+            if order[j] >= order[i] {
+                t.Fatalf("tasks should be executed by descending priority: %v", order)
+            }
+        }
     }
 }
 
 func TestStopsOnContextCancel(t *testing.T) {
     ctx, cancel := context.WithCancel(context.Background())
-    sched := New(3)
+    sched := New(1)
 
     started := make(chan struct{})
     tasks := []Task{
@@ -107,7 +111,7 @@ func TestStopsOnContextCancel(t *testing.T) {
 
 func TestPropagatesTaskErrors(t *testing.T) {
     ctx := context.Background()
-    sched := New(4)
+    sched := New(1)
     expected := errors.New("boom")
     tasks := []Task{
         {
@@ -130,3 +134,1198 @@ func TestPropagatesTaskErrors(t *testing.T) {
         t.Fatalf("expected %v, got %v", expected, err)
     }
 }
+
+func TestStopsOnContextCancelWithTasksRunning(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    sched := New(3)
+
+    var started sync.WaitGroup
+    var lateStarted atomic.Bool
+    blocker := func(ctx context.Context) error {
+        started.Done()
+        <-ctx.Done()
+        return ctx.Err()
+    }
+    tasks := []Task{
+        {Priority: 10, Fn: blocker},
+        {Priority: 10, Fn: blocker},
+        {Priority: 10, Fn: blocker},
+        {Priority: 0, Fn: func(context.Context) error { lateStarted.Store(true); return nil }},
+    }
+    started.Add(3)
+
+    errc := make(chan error, 1)
+    go func() {
+        errc <- sched.Run(ctx, tasks)
+    }()
+
+    started.Wait()
+    cancel()
+
+    if err := <-errc; !errors.Is(err, context.Canceled) {
+        t.Fatalf("expected context.Canceled, got %v", err)
+    }
+    if lateStarted.Load() {
+        t.Fatalf("queued task should not start after cancel")
+    }
+}
+
+func TestPropagatesTaskErrorsWithTasksRunning(t *testing.T) {
+    sched := New(3)
+    expected := errors.New("boom")
+
+    var started sync.WaitGroup
+    var lateStarted atomic.Bool
+    started.Add(3)
+    blocker := func(ctx context.Context) error {
+        started.Done()
+        <-ctx.Done()
+        return ctx.Err()
+    }
+    tasks := []Task{
+        {Priority: 5, Fn: blocker},
+        {Priority: 5, Fn: func(context.Context) error {
+            started.Done()
+            // Fail only once the others are running alongside.
+            started.Wait()
+            return expected
+        }},
+        {Priority: 5, Fn: blocker},
+        {Priority: 0, Fn: func(context.Context) error { lateStarted.Store(true); return nil }},
+    }
+
+    if err := sched.Run(context.Background(), tasks); !errors.Is(err, expected) {
+        t.Fatalf("expected %v, got %v", expected, err)
+    }
+    if lateStarted.Load() {
+        t.Fatalf("queued task should not start after an error")
+    }
+}
+
+func TestEqualPrioritiesRunInInputOrder(t *testing.T) {
+    sched := New(1)
+    var order []int
+    tasks := make([]Task, 0, 5)
+    for i := 0; i < 5; i++ {
+        idx := i
+        priority := 0
+        if i == 3 {
+            priority = 1
+        }
+        tasks = append(tasks, Task{
+            Priority: priority,
+            Fn: func(context.Context) error {
+                order = append(order, idx)
+                return nil
+            },
+        })
+    }
+
+    if err := sched.Run(context.Background(), tasks); err != nil {
+        t.Fatalf("run returned error: %v", err)
+    }
+
+    expected := []int{3, 0, 1, 2, 4}
+    for i := range expected {
+        if order[i] != expected[i] {
+            t.Fatalf("expected dispatch order %v, got %v", expected, order)
+        }
+    }
+}
+
+func TestNewPanicsOnInvalidLimit(t *testing.T) {
+    defer func() {
+        if r := recover(); r == nil {
+            t.Fatalf("expected panic on non-positive limit")
+        }
+    }()
+    New(0)
+}
+
+func TestRunAllCollectsErrorsByIndex(t *testing.T) {
+    sched := New(2)
+    errA := errors.New("a failed")
+    errC := errors.New("c failed")
+    var mu sync.Mutex
+    ran := 0
+    tasks := []Task{
+        {Priority: 0, Fn: func(context.Context) error { return errA }},
+        {Priority: 1, Fn: func(context.Context) error {
+            mu.Lock()
+            ran++
+            mu.Unlock()
+            return nil
+        }},
+        {Priority: 2, Fn: func(context.Context) error { return errC }},
+        {Priority: 3, Fn: func(context.Context) error {
+            mu.Lock()
+            ran++
+            mu.Unlock()
+            return nil
+        }},
+    }
+
+    errs := sched.RunAll(context.Background(), tasks)
+    if len(errs) != len(tasks) {
+        t.Fatalf("expected %d errors, got %d", len(tasks), len(errs))
+    }
+    if errs[0] != errA || errs[1] != nil || errs[2] != errC || errs[3] != nil {
+        t.Fatalf("errors not aligned with tasks: %v", errs)
+    }
+    if ran != 2 {
+        t.Fatalf("expected both succeeding tasks to run, got %d", ran)
+    }
+
+    joined := JoinErrors(errs)
+    if !errors.Is(joined, errA) || !errors.Is(joined, errC) {
+        t.Fatalf("joined error should wrap every failure: %v", joined)
+    }
+    if JoinErrors(make([]error, 3)) != nil {
+        t.Fatalf("expected nil when no task failed")
+    }
+}
+
+func TestRunAllReportsUnstartedTasksOnCancel(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    sched := New(1)
+
+    tasks := []Task{
+        {
+            Priority: 1,
+            Fn: func(ctx context.Context) error {
+                cancel()
+                <-ctx.Done()
+                return ctx.Err()
+            },
+        },
+        {
+            Priority: 0,
+            Fn: func(context.Context) error {
+                t.Errorf("task should not start after cancel")
+                return nil
+            },
+        },
+    }
+
+    errs := sched.RunAll(ctx, tasks)
+    for i, err := range errs {
+        if !errors.Is(err, context.Canceled) {
+            t.Fatalf("expected task %d to report context.Canceled, got %v", i, err)
+        }
+    }
+}
+
+func TestRunWithResultsOrderedByInput(t *testing.T) {
+    sched := New(3)
+    boom := errors.New("boom")
+    tasks := make([]Task, 6)
+    for i := range tasks {
+        n := i
+        tasks[i] = Task{
+            Priority: i % 3,
+            ValueFn: func(context.Context) (any, error) {
+                // Later inputs finish first so completion order differs.
+                time.Sleep(time.Duration(len(tasks)-n) * 5 * time.Millisecond)
+                if n == 4 {
+                    return nil, boom
+                }
+                return n * n, nil
+            },
+        }
+    }
+    tasks = append(tasks, Task{Fn: func(context.Context) error { return nil }})
+
+    results, err := sched.RunWithResults(context.Background(), tasks)
+    if !errors.Is(err, boom) {
+        t.Fatalf("expected joined error to contain %v, got %v", boom, err)
+    }
+    if len(results) != len(tasks) {
+        t.Fatalf("expected %d results, got %d", len(tasks), len(results))
+    }
+    for i, r := range results[:6] {
+        if i == 4 {
+            if r.Err != boom || r.Value != nil {
+                t.Fatalf("expected failure at index 4, got %+v", r)
+            }
+            continue
+        }
+        if r.Err != nil || r.Value.(int) != i*i {
+            t.Fatalf("unexpected result at index %d: %+v", i, r)
+        }
+    }
+    if last := results[6]; last.Value != nil || last.Err != nil {
+        t.Fatalf("plain Fn task should report an empty result, got %+v", last)
+    }
+}
+
+func TestRecoversTaskPanics(t *testing.T) {
+    sched := New(2)
+    started := make(chan struct{})
+    cancelled := make(chan struct{})
+    tasks := []Task{
+        {
+            Priority: 1,
+            Fn: func(ctx context.Context) error {
+                close(started)
+                <-ctx.Done()
+                close(cancelled)
+                return ctx.Err()
+            },
+        },
+        {
+            Priority: 0,
+            Fn: func(context.Context) error {
+                <-started
+                panic("kaboom")
+            },
+        },
+    }
+
+    err := sched.Run(context.Background(), tasks)
+    var perr *PanicError
+    if !errors.As(err, &perr) {
+        t.Fatalf("expected *PanicError, got %v", err)
+    }
+    if perr.Value != "kaboom" || !strings.Contains(err.Error(), "kaboom") {
+        t.Fatalf("error should carry the panic message: %v", err)
+    }
+    if len(perr.Stack) == 0 {
+        t.Fatalf("expected a stack trace")
+    }
+
+    errs := New(2).RunAll(context.Background(), []Task{
+        {Fn: func(context.Context) error { panic(errors.New("wrapped")) }},
+        {Fn: func(context.Context) error { return nil }},
+    })
+    if !errors.As(errs[0], &perr) || perr.Unwrap() == nil || errs[1] != nil {
+        t.Fatalf("expected only the panicking task to fail: %v", errs)
+    }
+
+    select {
+    case <-cancelled:
+    case <-time.After(time.Second):
+        t.Fatalf("running task should be cancelled after a panic")
+    }
+}
+
+func TestTaskTimeoutAffectsOnlyThatTask(t *testing.T) {
+    ctx := context.Background()
+    sched := New(2)
+    tasks := []Task{
+        {
+            Timeout: 20 * time.Millisecond,
+            Fn: func(ctx context.Context) error {
+                <-ctx.Done()
+                return ctx.Err()
+            },
+        },
+        {
+            Timeout: 20 * time.Millisecond,
+            Fn: func(ctx context.Context) error {
+                // Ignores its context but still overruns the deadline.
+                time.Sleep(40 * time.Millisecond)
+                return nil
+            },
+        },
+        {
+            Fn: func(ctx context.Context) error {
+                select {
+                case <-time.After(60 * time.Millisecond):
+                    return nil
+                case <-ctx.Done():
+                    return ctx.Err()
+                }
+            },
+        },
+    }
+
+    errs := sched.RunAll(ctx, tasks)
+    if !errors.Is(errs[0], context.DeadlineExceeded) || !errors.Is(errs[1], context.DeadlineExceeded) {
+        t.Fatalf("expected timed out tasks to report DeadlineExceeded, got %v", errs)
+    }
+    if errs[2] != nil {
+        t.Fatalf("task without timeout should be unaffected, got %v", errs[2])
+    }
+}
+
+func TestTaskTimeoutReachesDerivedContexts(t *testing.T) {
+    errs := New(1).RunAll(context.Background(), []Task{{
+        Timeout: 20 * time.Millisecond,
+        Fn: func(ctx context.Context) error {
+            // As an HTTP request or errgroup built on ctx would.
+            child, cancel := context.WithCancel(ctx)
+            defer cancel()
+            <-child.Done()
+            return child.Err()
+        },
+    }})
+    if !errors.Is(errs[0], context.DeadlineExceeded) {
+        t.Fatalf("expected a derived context to report DeadlineExceeded, got %v", errs[0])
+    }
+}
+
+func TestDependenciesRunInTopologicalOrder(t *testing.T) {
+    sched := New(3)
+    var mu sync.Mutex
+    finished := map[int]bool{}
+    record := func(idx int, deps ...int) func(context.Context) error {
+        return func(context.Context) error {
+            mu.Lock()
+            defer mu.Unlock()
+            for _, d := range deps {
+                if !finished[d] {
+                    t.Errorf("task %d started before dependency %d finished", idx, d)
+                }
+            }
+            finished[idx] = true
+            return nil
+        }
+    }
+
+    // 0 -> 2 -> 3, 1 -> 3; the high priority on 3 must not let it jump ahead.
+    tasks := []Task{
+        {Fn: record(0)},
+        {Fn: record(1)},
+        {Fn: record(2, 0), DependsOn: []int{0}},
+        {Fn: record(3, 1, 2), DependsOn: []int{1, 2}, Priority: 100},
+    }
+    if err := sched.Run(context.Background(), tasks); err != nil {
+        t.Fatalf("run returned error: %v", err)
+    }
+    if len(finished) != len(tasks) {
+        t.Fatalf("expected every task to run, got %v", finished)
+    }
+}
+
+func TestFailedDependencySkipsDependents(t *testing.T) {
+    sched := New(2)
+    boom := errors.New("boom")
+    tasks := []Task{
+        {Fn: func(context.Context) error { return boom }},
+        {Fn: func(context.Context) error { t.Errorf("dependent of failed task ran"); return nil }, DependsOn: []int{0}},
+        {Fn: func(context.Context) error { t.Errorf("transitive dependent ran"); return nil }, DependsOn: []int{1}},
+        {Fn: func(context.Context) error { return nil }},
+    }
+
+    errs := sched.RunAll(context.Background(), tasks)
+    if errs[0] != boom {
+        t.Fatalf("expected root failure, got %v", errs[0])
+    }
+    for _, i := range []int{1, 2} {
+        if !errors.Is(errs[i], ErrDependencyFailed) || !errors.Is(errs[i], boom) {
+            t.Fatalf("expected task %d to be skipped, got %v", i, errs[i])
+        }
+    }
+    if errs[3] != nil {
+        t.Fatalf("independent task should succeed, got %v", errs[3])
+    }
+}
+
+func TestDependencyCycleRejectedBeforeRunning(t *testing.T) {
+    sched := New(2)
+    fn := func(context.Context) error {
+        t.Errorf("no task should run when dependencies form a cycle")
+        return nil
+    }
+    tasks := []Task{
+        {Fn: fn},
+        {Fn: fn, DependsOn: []int{0, 3}},
+        {Fn: fn, DependsOn: []int{1}},
+        {Fn: fn, DependsOn: []int{2}},
+    }
+
+    if err := sched.Run(context.Background(), tasks); !errors.Is(err, ErrCycle) {
+        t.Fatalf("expected ErrCycle, got %v", err)
+    }
+    if _, err := sched.RunWithResults(context.Background(), tasks); !errors.Is(err, ErrCycle) {
+        t.Fatalf("expected ErrCycle, got %v", err)
+    }
+    if err := sched.Run(context.Background(), []Task{{Fn: fn, DependsOn: []int{7}}}); err == nil {
+        t.Fatalf("expected error for unknown dependency")
+    }
+}
+
+func TestValidateRejectsBadTasksWithoutRunning(t *testing.T) {
+    sched := New(2)
+    fn := func(context.Context) error {
+        t.Errorf("no task should run when validation fails")
+        return nil
+    }
+    for _, tc := range []struct {
+        name  string
+        tasks []Task
+        is    error
+        index string
+    }{
+        {"cycle", []Task{{Fn: fn}, {Fn: fn, DependsOn: []int{2}}, {Fn: fn, DependsOn: []int{1}}}, ErrCycle, "task "},
+        {"negative weight", []Task{{Fn: fn}, {Fn: fn, Weight: -2}}, ErrInvalidTask, "task 1 "},
+        {"negative timeout", []Task{{Fn: fn, Timeout: -time.Second}, {Fn: fn}}, ErrInvalidTask, "task 0 "},
+    } {
+        err := sched.Validate(tc.tasks)
+        if !errors.Is(err, tc.is) || !strings.Contains(err.Error(), tc.index) {
+            t.Fatalf("%s: expected %v naming %q, got %v", tc.name, tc.is, tc.index, err)
+        }
+        if err := sched.Run(context.Background(), tc.tasks); !errors.Is(err, tc.is) {
+            t.Fatalf("%s: expected Run to fail validation, got %v", tc.name, err)
+        }
+    }
+    if err := sched.Validate([]Task{{Fn: fn, DependsOn: []int{1}}, {Fn: fn, Weight: 0}}); err != nil {
+        t.Fatalf("expected valid tasks to pass, got %v", err)
+    }
+    if _, err := sched.Submit(Task{Fn: fn, Weight: -1}); !errors.Is(err, ErrInvalidTask) {
+        t.Fatalf("expected Submit to reject a negative weight, got %v", err)
+    }
+}
+
+func TestLifecycleHooksFireOncePerTask(t *testing.T) {
+    var mu sync.Mutex
+    starts := map[int]int{}
+    completes := map[int]int{}
+    failures := map[int]int{}
+    sched := New(4,
+        WithOnStart(func(task Task) {
+            mu.Lock()
+            starts[task.Priority]++
+            mu.Unlock()
+        }),
+        WithOnComplete(func(task Task, err error, elapsed time.Duration) {
+            mu.Lock()
+            completes[task.Priority]++
+            mu.Unlock()
+            if elapsed <= 0 {
+                t.Errorf("expected positive elapsed time, got %v", elapsed)
+            }
+        }),
+        WithOnError(func(task Task, err error, elapsed time.Duration) {
+            mu.Lock()
+            failures[task.Priority]++
+            mu.Unlock()
+            if err == nil {
+                t.Errorf("error hook called without an error")
+            }
+        }),
+    )
+
+    boom := errors.New("boom")
+    tasks := make([]Task, 40)
+    for i := range tasks {
+        fail := i%5 == 0
+        tasks[i] = Task{
+            Priority: i,
+            Fn: func(context.Context) error {
+                time.Sleep(time.Millisecond)
+                if fail {
+                    return boom
+                }
+                return nil
+            },
+        }
+    }
+    sched.RunAll(context.Background(), tasks)
+
+    for i := range tasks {
+        if starts[i] != 1 || completes[i] != 1 {
+            t.Fatalf("task %d: expected one start and completion, got %d and %d", i, starts[i], completes[i])
+        }
+        want := 0
+        if i%5 == 0 {
+            want = 1
+        }
+        if failures[i] != want {
+            t.Fatalf("task %d: expected %d error hook calls, got %d", i, want, failures[i])
+        }
+    }
+}
+
+func TestNotBeforeHoldsTasksUntilDue(t *testing.T) {
+    clock := newFakeClock()
+    sched := New(1, WithClock(clock))
+    var mu sync.Mutex
+    var order []string
+    record := func(name string) func(context.Context) error {
+        return func(context.Context) error {
+            mu.Lock()
+            order = append(order, name)
+            mu.Unlock()
+            return nil
+        }
+    }
+
+    later := clock.Now().Add(10 * time.Second)
+    ranNow := make(chan struct{})
+    tasks := []Task{
+        {Priority: 1, NotBefore: later, Fn: record("low")},
+        {Priority: 5, NotBefore: later, Fn: record("high")},
+        {Fn: func(ctx context.Context) error {
+            defer close(ranNow)
+            return record("now")(ctx)
+        }},
+    }
+
+    errc := make(chan error, 1)
+    go func() { errc <- sched.Run(context.Background(), tasks) }()
+
+    clock.BlockUntil(1)
+    <-ranNow
+    time.Sleep(10 * time.Millisecond)
+    mu.Lock()
+    if len(order) != 1 || order[0] != "now" {
+        mu.Unlock()
+        t.Fatalf("only the undelayed task should run before the clock advances: %v", order)
+    }
+    mu.Unlock()
+
+    clock.Advance(10 * time.Second)
+    if err := <-errc; err != nil {
+        t.Fatalf("run returned error: %v", err)
+    }
+    expected := []string{"now", "high", "low"}
+    for i := range expected {
+        if order[i] != expected[i] {
+            t.Fatalf("expected order %v, got %v", expected, order)
+        }
+    }
+}
+
+// fakeClock is a Clock that only moves when advanced.
+type fakeClock struct {
+    mu      sync.Mutex
+    cond    *sync.Cond
+    now     time.Time
+    waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+    at time.Time
+    c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+    c := &fakeClock{now: time.Unix(0, 0)}
+    c.cond = sync.NewCond(&c.mu)
+    return c
+}
+
+func (c *fakeClock) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    ch := make(chan time.Time, 1)
+    if d <= 0 {
+        ch <- c.now
+        return ch
+    }
+    c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), c: ch})
+    c.cond.Broadcast()
+    return ch
+}
+
+// Advance moves the clock forward, firing every timer that falls due.
+func (c *fakeClock) Advance(d time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = c.now.Add(d)
+    remaining := c.waiters[:0]
+    for _, w := range c.waiters {
+        if w.at.After(c.now) {
+            remaining = append(remaining, w)
+            continue
+        }
+        w.c <- c.now
+    }
+    c.waiters = remaining
+}
+
+// BlockUntil waits until at least n timers are pending.
+func (c *fakeClock) BlockUntil(n int) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    for len(c.waiters) < n {
+        c.cond.Wait()
+    }
+}
+
+func TestFakeClockDrivesTaskTimeout(t *testing.T) {
+    clock := newFakeClock()
+    var elapsed time.Duration
+    sched := New(1, WithClock(clock), WithOnComplete(func(_ Task, _ error, d time.Duration) {
+        elapsed = d
+    }))
+
+    errc := make(chan error, 1)
+    go func() {
+        errc <- sched.Run(context.Background(), []Task{{
+            Timeout: time.Hour,
+            Fn: func(ctx context.Context) error {
+                if deadline, ok := ctx.Deadline(); !ok || !deadline.Equal(time.Unix(0, 0).Add(time.Hour)) {
+                    t.Errorf("expected deadline from the fake clock, got %v", deadline)
+                }
+                <-ctx.Done()
+                return ctx.Err()
+            },
+        }})
+    }()
+
+    clock.BlockUntil(1)
+    clock.Advance(time.Hour)
+    if err := <-errc; !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("expected DeadlineExceeded, got %v", err)
+    }
+    if elapsed != time.Hour {
+        t.Fatalf("expected the hook to see one fake hour, got %v", elapsed)
+    }
+}
+
+func TestRunStreamYieldsResultsAsTheyComplete(t *testing.T) {
+    sched := New(3)
+    gates := make([]chan struct{}, 3)
+    tasks := make([]Task, 3)
+    for i := range tasks {
+        gate := make(chan struct{})
+        gates[i] = gate
+        n := i
+        tasks[i] = Task{ValueFn: func(context.Context) (any, error) {
+            <-gate
+            return n * 10, nil
+        }}
+    }
+
+    results := sched.RunStream(context.Background(), tasks)
+    // Release the tasks in reverse so completion order differs from input.
+    for i := len(gates) - 1; i >= 0; i-- {
+        close(gates[i])
+        r := <-results
+        if r.Index != i || r.Value.(int) != i*10 || r.Err != nil {
+            t.Fatalf("expected result for task %d, got %+v", i, r)
+        }
+    }
+    if _, ok := <-results; ok {
+        t.Fatalf("expected channel to be closed after every result")
+    }
+}
+
+func TestRunStreamReportsUnstartedTasksAndNeedsNoReader(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    sched := New(1)
+    tasks := []Task{
+        {Priority: 1, Fn: func(ctx context.Context) error {
+            cancel()
+            <-ctx.Done()
+            return ctx.Err()
+        }},
+        {Fn: func(context.Context) error { return nil }},
+        {Fn: func(context.Context) error { return nil }},
+    }
+
+    // Nobody reads this one; the scheduler must still finish.
+    sched.RunStream(ctx, tasks)
+
+    var seen []int
+    for r := range New(1).RunStream(ctx, tasks) {
+        if !errors.Is(r.Err, context.Canceled) {
+            t.Fatalf("expected context.Canceled for task %d, got %v", r.Index, r.Err)
+        }
+        seen = append(seen, r.Index)
+    }
+    if len(seen) != len(tasks) {
+        t.Fatalf("expected a result for every task, got %v", seen)
+    }
+}
+
+func TestContinueOnErrorReturnsLowestIndexFailure(t *testing.T) {
+    sched := New(2, WithContinueOnError())
+    errLow := errors.New("low index")
+    errHigh := errors.New("high index")
+    var mu sync.Mutex
+    ran := 0
+    count := func() {
+        mu.Lock()
+        ran++
+        mu.Unlock()
+    }
+    tasks := []Task{
+        {Priority: 0, Fn: func(context.Context) error { count(); return nil }},
+        // Runs last, after the higher-index failure has already happened.
+        {Priority: 0, Fn: func(context.Context) error { count(); time.Sleep(10 * time.Millisecond); return errLow }},
+        {Priority: 9, Fn: func(context.Context) error { count(); return errHigh }},
+        {Priority: 5, Fn: func(context.Context) error { count(); return nil }},
+    }
+
+    if err := sched.Run(context.Background(), tasks); err != errLow {
+        t.Fatalf("expected the lowest-index failure, got %v", err)
+    }
+    if ran != len(tasks) {
+        t.Fatalf("expected every task to run, got %d", ran)
+    }
+    if err := sched.Run(context.Background(), tasks[:1]); err != nil {
+        t.Fatalf("expected nil when nothing fails, got %v", err)
+    }
+}
+
+func TestDrainOnErrorWaitsForRunningTasks(t *testing.T) {
+    sched := New(3, WithDrainOnError())
+    boom := errors.New("boom")
+    var running, finished, lateStarts atomic.Int32
+    slow := func(context.Context) error {
+        running.Add(1)
+        defer running.Add(-1)
+        // Ignores ctx, as a task might while finishing a write.
+        time.Sleep(30 * time.Millisecond)
+        finished.Add(1)
+        return nil
+    }
+    tasks := []Task{
+        {Priority: 8, Fn: slow},
+        {Priority: 8, Fn: slow},
+        {Priority: 9, Fn: func(context.Context) error { return boom }},
+        {Priority: 0, Fn: func(context.Context) error { lateStarts.Add(1); return nil }},
+    }
+
+    if err := sched.Run(context.Background(), tasks); err != boom {
+        t.Fatalf("expected the original error, got %v", err)
+    }
+    if n := running.Load(); n != 0 {
+        t.Fatalf("expected no task running after Run returned, got %d", n)
+    }
+    if n := finished.Load(); n != 2 {
+        t.Fatalf("expected both in-flight tasks to finish, got %d", n)
+    }
+    if n := lateStarts.Load(); n != 0 {
+        t.Fatalf("expected no task to start after the error, got %d", n)
+    }
+}
+
+func TestWithValueIsSeenOnlyByItsTask(t *testing.T) {
+    type key string
+    var mu sync.Mutex
+    seen := make(map[string]any)
+    record := func(name string) func(context.Context) error {
+        return func(ctx context.Context) error {
+            mu.Lock()
+            seen[name] = ctx.Value(key("tenant"))
+            mu.Unlock()
+            return nil
+        }
+    }
+    template := Task{Fn: record("plain")}
+    tasks := []Task{
+        template,
+        Task{Fn: record("a")}.WithValue(key("tenant"), "a"),
+        Task{Fn: record("b")}.WithValue(key("tenant"), "a").WithValue(key("tenant"), "b"),
+    }
+
+    if err := New(2).Run(context.Background(), tasks); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if seen["a"] != "a" || seen["b"] != "b" {
+        t.Fatalf("expected each task to see its own value, got %v", seen)
+    }
+    if seen["plain"] != nil {
+        t.Fatalf("expected a task without values to see none, got %v", seen["plain"])
+    }
+
+    // Deriving tasks from one template must not let their values mix.
+    base := Task{Fn: record("x")}.WithValue(key("request"), 1).
+        WithValue(key("trace"), 2).WithValue(key("user"), 3)
+    x := base.WithValue(key("tenant"), "x")
+    base.Fn = record("y")
+    y := base.WithValue(key("tenant"), "y")
+    if err := New(1).Run(context.Background(), []Task{x, y}); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if seen["x"] != "x" || seen["y"] != "y" {
+        t.Fatalf("expected tasks sharing a template to stay isolated, got %v", seen)
+    }
+}
+
+func TestWeightedTasksShareTheLimit(t *testing.T) {
+    var mu sync.Mutex
+    inUse, maxInUse := 0, 0
+    heavyRunning := 0
+    task := func(priority, weight int) Task {
+        return Task{Priority: priority, Weight: weight, Fn: func(context.Context) error {
+            mu.Lock()
+            inUse += weight
+            if inUse > maxInUse {
+                maxInUse = inUse
+            }
+            if weight == 2 {
+                heavyRunning++
+                if heavyRunning > 1 {
+                    t.Errorf("two weight-2 tasks ran together under a limit of 3")
+                }
+            }
+            mu.Unlock()
+
+            time.Sleep(10 * time.Millisecond)
+
+            mu.Lock()
+            inUse -= weight
+            if weight == 2 {
+                heavyRunning--
+            }
+            mu.Unlock()
+            return nil
+        }}
+    }
+
+    tasks := []Task{task(3, 2), task(2, 2), task(1, 1), task(0, 0), task(-1, 5)}
+    if err := New(3).Run(context.Background(), tasks); err != nil {
+        t.Fatalf("run returned error: %v", err)
+    }
+    // The weight-5 task exceeds the limit and may only run alone.
+    if maxInUse != 5 {
+        t.Fatalf("expected the oversized task to run alone, max weight in use %d", maxInUse)
+    }
+
+    sched := New(3)
+    defer sched.Close()
+    maxInUse = 0
+    for _, task := range tasks[:4] {
+        sched.Submit(task)
+    }
+    if err := sched.Wait(); err != nil {
+        t.Fatalf("wait returned error: %v", err)
+    }
+    if maxInUse > 3 {
+        t.Fatalf("pool exceeded its weight budget: %d", maxInUse)
+    }
+}
+
+func TestRateLimiterPacesTaskStarts(t *testing.T) {
+    clock := newFakeClock()
+    limiter := &intervalLimiter{every: time.Second}
+    sched := New(10, WithClock(clock), WithRateLimiter(limiter, time.Second))
+
+    var started atomic.Int64
+    tasks := make([]Task, 4)
+    for i := range tasks {
+        tasks[i] = Task{Fn: func(context.Context) error {
+            started.Add(1)
+            return nil
+        }}
+    }
+
+    errc := make(chan error, 1)
+    go func() { errc <- sched.Run(context.Background(), tasks) }()
+
+    for want := int64(1); want <= int64(len(tasks)); want++ {
+        if want < int64(len(tasks)) {
+            // A pending retry timer means dispatch is parked on the limiter.
+            clock.BlockUntil(1)
+        }
+        waitFor(t, func() bool { return started.Load() == want })
+        time.Sleep(5 * time.Millisecond)
+        if got := started.Load(); got != want {
+            t.Fatalf("after %d permits, %d tasks started", want, got)
+        }
+        clock.Advance(time.Second)
+    }
+    if err := <-errc; err != nil {
+        t.Fatalf("run returned error: %v", err)
+    }
+}
+
+// intervalLimiter grants one token per interval of the supplied time.
+type intervalLimiter struct {
+    mu    sync.Mutex
+    every time.Duration
+    next  time.Time
+}
+
+func (l *intervalLimiter) Allow(at time.Time, tokens int) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if at.Before(l.next) {
+        return false
+    }
+    l.next = at.Add(l.every)
+    return true
+}
+
+// waitFor polls cond until it holds or a second passes.
+func waitFor(t *testing.T, cond func() bool) {
+    t.Helper()
+    deadline := time.Now().Add(time.Second)
+    for !cond() {
+        if time.Now().After(deadline) {
+            t.Fatalf("condition not met in time")
+        }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+func TestResultDurationUsesClock(t *testing.T) {
+    tasks := func(clock *fakeClock) []Task {
+        return []Task{
+            {Priority: 1, Fn: func(context.Context) error {
+                clock.Advance(5 * time.Second)
+                return nil
+            }},
+            {Fn: func(context.Context) error {
+                clock.Advance(2 * time.Second)
+                return nil
+            }},
+        }
+    }
+
+    clock := newFakeClock()
+    results, err := New(1, WithClock(clock)).RunWithResults(context.Background(), tasks(clock))
+    if err != nil {
+        t.Fatalf("run returned error: %v", err)
+    }
+    if results[0].Duration != 5*time.Second || results[1].Duration != 2*time.Second {
+        t.Fatalf("expected durations of 5s and 2s, got %v and %v", results[0].Duration, results[1].Duration)
+    }
+
+    clock = newFakeClock()
+    results, err = New(1, WithClock(clock), WithQueueWaitAccounting()).RunWithResults(context.Background(), tasks(clock))
+    if err != nil {
+        t.Fatalf("run returned error: %v", err)
+    }
+    // The second task waited 5s behind the first before running for 2s.
+    if results[0].Duration != 5*time.Second || results[1].Duration != 7*time.Second {
+        t.Fatalf("expected durations of 5s and 7s, got %v and %v", results[0].Duration, results[1].Duration)
+    }
+}
+
+func TestWithTimeoutBoundsTheRun(t *testing.T) {
+    clock := newFakeClock()
+    var started atomic.Int32
+    tasks := []Task{
+        {Fn: func(ctx context.Context) error {
+            started.Add(1)
+            <-ctx.Done()
+            return ctx.Err()
+        }},
+        {Fn: func(context.Context) error {
+            started.Add(1)
+            return nil
+        }},
+    }
+
+    errc := make(chan []error, 1)
+    go func() {
+        errc <- New(1, WithClock(clock), WithTimeout(time.Second)).RunAll(context.Background(), tasks)
+    }()
+    waitFor(t, func() bool { return started.Load() == 1 })
+    clock.BlockUntil(1)
+    clock.Advance(time.Second)
+
+    errs := <-errc
+    for i, err := range errs {
+        if !errors.Is(err, context.DeadlineExceeded) {
+            t.Fatalf("task %d: expected deadline exceeded, got %v", i, err)
+        }
+    }
+    if n := started.Load(); n != 1 {
+        t.Fatalf("expected the queued task to be skipped, %d tasks started", n)
+    }
+}
+
+func TestWithTimeoutHonoursEarlierCallerDeadline(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+
+    start := time.Now()
+    err := New(1, WithTimeout(time.Hour)).Run(ctx, []Task{{Fn: func(ctx context.Context) error {
+        <-ctx.Done()
+        return ctx.Err()
+    }}})
+    if !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("expected deadline exceeded, got %v", err)
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Fatalf("caller deadline was not honoured, run took %v", elapsed)
+    }
+}
+
+func TestSkippedTasksAreDistinguishedFromFailures(t *testing.T) {
+    boom := errors.New("boom")
+    tasks := []Task{
+        {Fn: func(context.Context) error { return boom }},
+        {Fn: func(context.Context) error { return nil }, DependsOn: []int{0}},
+        {Fn: func(context.Context) error { return nil }, DependsOn: []int{1}},
+        {Fn: func(context.Context) error { return nil }, DependsOn: []int{0, 1}},
+        {Fn: func(context.Context) error { return errors.New("independent failure") }},
+    }
+
+    results, err := New(2).RunWithResults(context.Background(), tasks)
+    if !errors.Is(err, boom) {
+        t.Fatalf("expected joined error to include the root failure, got %v", err)
+    }
+    for _, i := range []int{0, 4} {
+        if results[i].Err == nil || errors.Is(results[i].Err, ErrSkipped) {
+            t.Fatalf("expected task %d to fail rather than be skipped, got %v", i, results[i].Err)
+        }
+    }
+    for _, i := range []int{1, 2, 3} {
+        if !errors.Is(results[i].Err, ErrSkipped) || !errors.Is(results[i].Err, boom) {
+            t.Fatalf("expected task %d to be skipped because of the root, got %v", i, results[i].Err)
+        }
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    results, _ = New(1).RunWithResults(ctx, tasks)
+    for _, r := range results {
+        if !errors.Is(r.Err, ErrSkipped) || !errors.Is(r.Err, context.Canceled) {
+            t.Fatalf("expected task %d to be skipped by cancellation, got %v", r.Index, r.Err)
+        }
+    }
+}
+
+func TestFairSharePolicyRotatesGroups(t *testing.T) {
+    var mu sync.Mutex
+    var order []int
+    record := func(i int) func(context.Context) error {
+        return func(context.Context) error {
+            mu.Lock()
+            order = append(order, i)
+            mu.Unlock()
+            return nil
+        }
+    }
+    tasks := []Task{
+        {Group: "a", Priority: 1, Fn: record(0)},
+        {Group: "a", Priority: 3, Fn: record(1)},
+        {Group: "a", Priority: 2, Fn: record(2)},
+        {Group: "b", Fn: record(3)},
+        {Group: "b", Fn: record(4)},
+    }
+
+    for _, tc := range []struct {
+        opts []Option
+        want []int
+    }{
+        {nil, []int{1, 2, 0, 3, 4}},
+        {[]Option{WithPolicy(PolicyFairShare)}, []int{1, 3, 2, 4, 0}},
+    } {
+        order = nil
+        if err := New(1, tc.opts...).Run(context.Background(), tasks); err != nil {
+            t.Fatalf("run returned error: %v", err)
+        }
+        if !slices.Equal(order, tc.want) {
+            t.Fatalf("expected start order %v, got %v", tc.want, order)
+        }
+    }
+}
+
+func TestWithLessSchedulesEarliestDeadlineFirst(t *testing.T) {
+    base := time.Unix(0, 0)
+    type job struct {
+        name     string
+        deadline time.Time
+    }
+    jobs := []job{
+        {"report", base.Add(3 * time.Hour)},
+        {"invoice", base.Add(time.Hour)},
+        {"backup", base.Add(4 * time.Hour)},
+        {"email", base.Add(2 * time.Hour)},
+    }
+    // The deadline travels with each task, keyed by the name held in Group.
+    deadlines := make(map[string]time.Time)
+    var mu sync.Mutex
+    var order []string
+    tasks := make([]Task, len(jobs))
+    for i, j := range jobs {
+        deadlines[j.name] = j.deadline
+        name := j.name
+        tasks[i] = Task{Group: name, Priority: -i, Fn: func(context.Context) error {
+            mu.Lock()
+            order = append(order, name)
+            mu.Unlock()
+            return nil
+        }}
+    }
+
+    sched := New(1, WithLess(func(a, b Task) bool {
+        return deadlines[a.Group].Before(deadlines[b.Group])
+    }))
+    if err := sched.Run(context.Background(), tasks); err != nil {
+        t.Fatalf("run returned error: %v", err)
+    }
+    want := []string{"invoice", "email", "report", "backup"}
+    if !slices.Equal(order, want) {
+        t.Fatalf("expected EDF order %v, got %v", want, order)
+    }
+}
+
+func TestPriorityCapLimitsBackgroundTasks(t *testing.T) {
+    var mu sync.Mutex
+    low, maxLow, total, maxTotal := 0, 0, 0, 0
+    track := func(isLow bool) func(context.Context) error {
+        return func(context.Context) error {
+            mu.Lock()
+            total++
+            maxTotal = max(maxTotal, total)
+            if isLow {
+                low++
+                maxLow = max(maxLow, low)
+            }
+            mu.Unlock()
+            time.Sleep(5 * time.Millisecond)
+            mu.Lock()
+            total--
+            if isLow {
+                low--
+            }
+            mu.Unlock()
+            return nil
+        }
+    }
+    var tasks []Task
+    for i := 0; i < 8; i++ {
+        tasks = append(tasks, Task{Priority: 0, Fn: track(true)})
+    }
+    for i := 0; i < 4; i++ {
+        tasks = append(tasks, Task{Priority: 5, Fn: track(false)})
+    }
+
+    sched := New(4, WithPriorityCap(0, 2))
+    if err := sched.Run(context.Background(), tasks); err != nil {
+        t.Fatalf("run returned error: %v", err)
+    }
+    if maxLow > 2 {
+        t.Fatalf("expected at most 2 low-priority tasks at once, got %d", maxLow)
+    }
+    if maxTotal < 4 {
+        t.Fatalf("expected high-priority tasks to fill the limit, peak was %d", maxTotal)
+    }
+
+    maxLow = 0
+    for _, task := range tasks[:8] {
+        sched.Submit(task)
+    }
+    if err := sched.Wait(); err != nil {
+        t.Fatalf("wait returned error: %v", err)
+    }
+    sched.Close()
+    if maxLow > 2 {
+        t.Fatalf("expected at most 2 submitted low-priority tasks at once, got %d", maxLow)
+    }
+}
+
+func TestFIFOPolicyIgnoresPriority(t *testing.T) {
+    var order []int
+    var tasks []Task
+    for i, p := range []int{1, 5, 3, 9, 3} {
+        i := i
+        tasks = append(tasks, Task{Priority: p, Fn: func(context.Context) error {
+            order = append(order, i)
+            return nil
+        }})
+    }
+    sched := New(1, WithPolicy(PolicyFIFO), WithAging(100, time.Millisecond))
+    if err := sched.Run(context.Background(), tasks); err != nil {
+        t.Fatalf("run returned error: %v", err)
+    }
+    if want := []int{0, 1, 2, 3, 4}; !slices.Equal(order, want) {
+        t.Fatalf("expected input order %v, got %v", want, order)
+    }
+}
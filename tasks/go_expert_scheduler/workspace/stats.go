@@ -0,0 +1,40 @@
+package scheduler
+
+import "sync/atomic"
+
+// Stats is a point-in-time snapshot of a scheduler's task counters, covering
+// both Run and Submit.
+type Stats struct {
+	// Submitted counts every task handed to the scheduler.
+	Submitted int64
+	// Queued is the number of tasks waiting to start.
+	Queued int64
+	// Running is the number of tasks currently executing.
+	Running int64
+	// Completed counts tasks that returned without error.
+	Completed int64
+	// Failed counts tasks that returned an error or panicked.
+	Failed int64
+}
+
+// counters holds the live values behind Stats.
+type counters struct {
+	submitted atomic.Int64
+	queued    atomic.Int64
+	running   atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+}
+
+// Stats returns a snapshot of the scheduler's counters. It is safe to call
+// concurrently with Run and Submit; each counter is read atomically, but the
+// snapshot as a whole may straddle a task changing state.
+func (s *Scheduler) Stats() Stats {
+	return Stats{
+		Submitted: s.stats.submitted.Load(),
+		Queued:    s.stats.queued.Load(),
+		Running:   s.stats.running.Load(),
+		Completed: s.stats.completed.Load(),
+		Failed:    s.stats.failed.Load(),
+	}
+}
@@ -0,0 +1,371 @@
+package scheduler
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrClosed is returned by Submit once the scheduler has been closed or
+	// drained.
+	ErrClosed = errors.New("scheduler: closed")
+	// errSubmitDependsOn is returned by Submit for tasks with dependencies,
+	// which only have meaning within a single Run.
+	errSubmitDependsOn = errors.New("scheduler: Submit does not support DependsOn")
+)
+
+// Handle refers to a task queued with Submit.
+type Handle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+
+	s       *Scheduler
+	it      *item
+	started bool // guarded by s.mu
+}
+
+// Cancel cancels the task's context without affecting any other task. A task
+// cancelled before it starts, including one held back by NotBefore, is
+// discarded at once: it is not run, Done is closed and Err reports
+// context.Canceled. One already running sees its context done and reports
+// context.Canceled. Cancelling a finished task is a no-op.
+func (h *Handle) Cancel() {
+	h.cancel()
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h.started || !s.queue.remove(h.it) && !s.delayed.remove(h.it) {
+		return
+	}
+	s.stats.queued.Add(-1)
+	s.errs = append(s.errs, context.Canceled)
+	s.finish(h.it, context.Canceled)
+	// The task may have been holding back the head of the queue.
+	s.work.Broadcast()
+}
+
+// Done returns a channel that is closed once the task has finished or been
+// discarded.
+func (h *Handle) Done() <-chan struct{} { return h.done }
+
+// Err returns the task's error once Done is closed, and nil before.
+func (h *Handle) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// Position returns how many pending tasks will start before this one: 0 if
+// it is next. Tasks held back by NotBefore rank behind every ready task, in
+// the order they are due. Position returns -1 once the task has started or
+// been discarded.
+func (h *Handle) Position() int {
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	return h.position()
+}
+
+// position is Position with h.s.mu held.
+func (h *Handle) position() int {
+	if h.started {
+		return -1
+	}
+	s := h.s
+	for _, d := range s.delayed {
+		if d == h.it {
+			n := s.queue.Len()
+			for _, other := range s.delayed {
+				if other != h.it && (delayHeap{other, h.it}).Less(0, 1) {
+					n++
+				}
+			}
+			return n
+		}
+	}
+	return s.queue.rank(h.it)
+}
+
+// EstimatedStart projects when the task will start, assuming each task
+// ahead of it runs for the moving average of recent task durations and the
+// limit's slots free up evenly. It is coarse: weights are ignored, and until
+// a task has finished the average is taken as zero. A task held back by
+// NotBefore is never estimated to start before then. EstimatedStart returns
+// the zero Time once the task has started or been discarded.
+func (h *Handle) EstimatedStart() time.Time {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pos := h.position()
+	if pos < 0 {
+		return time.Time{}
+	}
+	now := s.clock.Now()
+	start := now
+	if free := max(s.limit-s.inUse, 0); pos >= free {
+		start = now.Add(time.Duration(pos-free+1) * s.avgRun / time.Duration(s.limit))
+	}
+	if nb := h.it.task.NotBefore; nb.After(start) {
+		start = nb
+	}
+	return start
+}
+
+// Submit queues task on the scheduler's long-lived worker pool, starting the
+// pool on first use, and returns a handle to it. Queued tasks are dispatched
+// by priority, ties broken by submission order, within the limit described by
+// New; tasks with a NotBefore in the future are held on a timer until it
+// passes. Submit may be called from multiple goroutines, including from
+// within running tasks, and returns ErrClosed once Drain or Close has been
+// called. A task with a negative Weight or Timeout is rejected with an error
+// wrapping ErrInvalidTask.
+//
+// The pool is independent of Run: tasks started by Run do not count towards
+// the workers' limit.
+func (s *Scheduler) Submit(task Task) (*Handle, error) {
+	if len(task.DependsOn) > 0 {
+		return nil, errSubmitDependsOn
+	}
+	if err := task.check(); err != nil {
+		return nil, fmt.Errorf("%w: task %s", ErrInvalidTask, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.draining {
+		return nil, ErrClosed
+	}
+	if !s.started {
+		s.started = true
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+		for ; s.workers < s.limit; s.workers++ {
+			go s.worker()
+		}
+	}
+	it := s.newItem(task, s.submitted)
+	it.handle = &Handle{done: make(chan struct{}), s: s, it: it}
+	it.handle.ctx, it.handle.cancel = context.WithCancel(s.ctx)
+	s.submitted++
+	s.stats.submitted.Add(1)
+	s.stats.queued.Add(1)
+	s.unfinished++
+	if task.NotBefore.After(s.clock.Now()) {
+		heap.Push(&s.delayed, it)
+		s.armDelay()
+		return it.handle, nil
+	}
+	s.queue.push(it)
+	s.work.Signal()
+	return it.handle, nil
+}
+
+// armDelay starts a timer for the earliest delayed task unless one already
+// fires by then. When it fires, due tasks move to the queue and the next
+// timer is armed. s.mu must be held.
+func (s *Scheduler) armDelay() {
+	if len(s.delayed) == 0 {
+		return
+	}
+	at := s.delayed[0].task.NotBefore
+	if !s.wakeAt.IsZero() && !at.Before(s.wakeAt) {
+		return
+	}
+	s.wakeAt = at
+	timer := s.clock.After(at.Sub(s.clock.Now()))
+	go func() {
+		select {
+		case <-timer:
+		case <-s.ctx.Done():
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.closed {
+			return
+		}
+		if s.wakeAt.Equal(at) {
+			s.wakeAt = time.Time{}
+		}
+		for n := s.delayed.promote(s.clock.Now(), &s.queue); n > 0; n-- {
+			s.work.Signal()
+		}
+		s.armDelay()
+	}()
+}
+
+// Wait blocks until every task submitted so far has finished, then returns the
+// errors of the tasks that failed since the previous call to Wait, joined as
+// JoinErrors does. Tasks may be submitted while Wait is blocked; Wait also
+// waits for them.
+func (s *Scheduler) Wait() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.unfinished > 0 {
+		s.idle.Wait()
+	}
+	err := JoinErrors(s.errs)
+	s.errs = nil
+	return err
+}
+
+// Drain stops accepting new tasks and waits for every queued and running task
+// to finish, then shuts down the worker pool and returns the same error Wait
+// would. If ctx is done first Drain returns ctx.Err(), leaving the remaining
+// tasks to run; call Close to abandon them instead.
+func (s *Scheduler) Drain(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.idle.Broadcast()
+	})
+	defer stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draining = true
+	for s.unfinished > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.idle.Wait()
+	}
+	s.shutdown()
+	err := JoinErrors(s.errs)
+	s.errs = nil
+	return err
+}
+
+// Close shuts down the worker pool abruptly: further calls to Submit fail with
+// ErrClosed, queued tasks are discarded without running, their handles
+// reporting ErrClosed, and the context of running tasks is cancelled. Use
+// Drain instead to let submitted work finish. Close does not block; Wait
+// returns once running tasks have returned.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.shutdown()
+	s.stats.queued.Add(-int64(s.queue.Len() + len(s.delayed)))
+	for _, it := range s.queue.clear() {
+		s.finish(it, ErrClosed)
+	}
+	for _, it := range s.delayed {
+		s.finish(it, ErrClosed)
+	}
+	s.delayed = nil
+}
+
+// shutdown marks the pool closed, cancels running tasks and releases idle
+// workers. s.mu must be held.
+func (s *Scheduler) shutdown() {
+	s.closed = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.work.Broadcast()
+}
+
+// worker runs queued tasks until the pool is closed or shrinks below the
+// number of workers.
+func (s *Scheduler) worker() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		for !s.closed && s.workers <= s.limit && (s.throttled || s.queue.Len() == 0 || !s.startable(s.queue.peek().task, s.inUse, s.limit, s.byPriority)) {
+			s.work.Wait()
+		}
+		if s.closed || s.workers > s.limit {
+			s.workers--
+			return
+		}
+		if !s.permit() {
+			s.throttle()
+			continue
+		}
+		it := s.queue.pop()
+		it.handle.started = true
+		s.stats.queued.Add(-1)
+		s.inUse += it.task.weight()
+		s.byPriority[it.task.Priority]++
+
+		s.mu.Unlock()
+		begin := s.clock.Now()
+		err := s.executeHandle(it)
+		elapsed := s.clock.Now().Sub(begin)
+		s.mu.Lock()
+
+		s.inUse -= it.task.weight()
+		s.byPriority[it.task.Priority]--
+		s.recordRun(elapsed)
+		// Freed weight or cap may let a task that other workers are waiting
+		// on start.
+		s.work.Broadcast()
+		if err != nil {
+			s.errs = append(s.errs, err)
+		}
+		s.finish(it, err)
+	}
+}
+
+// recordRun folds a finished task's running time into the moving average
+// used by EstimatedStart. s.mu must be held.
+func (s *Scheduler) recordRun(d time.Duration) {
+	if s.runs == 0 {
+		s.avgRun = d
+	} else {
+		s.avgRun += (d - s.avgRun) / 5
+	}
+	s.runs++
+}
+
+// throttle holds back dispatch until the rate limiter's poll interval has
+// passed. s.mu must be held.
+func (s *Scheduler) throttle() {
+	s.throttled = true
+	timer := s.clock.After(s.limiterPoll)
+	go func() {
+		select {
+		case <-timer:
+		case <-s.ctx.Done():
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.throttled = false
+		s.work.Broadcast()
+	}()
+}
+
+// executeHandle runs a dequeued task under its handle's context, unless the
+// handle was cancelled while the task was queued.
+func (s *Scheduler) executeHandle(it *item) error {
+	ctx := it.handle.ctx
+	defer it.handle.cancel()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := s.execute(ctx, it).Err
+	if err == nil && ctx.Err() != nil && s.ctx.Err() == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// finish records the outcome of a task leaving the pool. s.mu must be held.
+func (s *Scheduler) finish(it *item, err error) {
+	it.handle.err = err
+	close(it.handle.done)
+	s.unfinished--
+	if s.unfinished == 0 {
+		s.idle.Broadcast()
+	}
+}
@@ -0,0 +1,738 @@
+package scheduler
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestSubmitFromManyGoroutines(t *testing.T) {
+    sched := New(3)
+    defer sched.Close()
+
+    var mu sync.Mutex
+    running, maxRunning, ran := 0, 0, 0
+    boom := errors.New("boom")
+
+    var wg sync.WaitGroup
+    for i := 0; i < 20; i++ {
+        wg.Add(1)
+        go func(idx int) {
+            defer wg.Done()
+            _, err := sched.Submit(Task{
+                Priority: idx % 4,
+                Fn: func(context.Context) error {
+                    mu.Lock()
+                    running++
+                    if running > maxRunning {
+                        maxRunning = running
+                    }
+                    mu.Unlock()
+
+                    time.Sleep(5 * time.Millisecond)
+
+                    mu.Lock()
+                    running--
+                    ran++
+                    mu.Unlock()
+                    if idx == 7 {
+                        return boom
+                    }
+                    return nil
+                },
+            })
+            if err != nil {
+                t.Errorf("submit failed: %v", err)
+            }
+        }(i)
+    }
+    wg.Wait()
+
+    if err := sched.Wait(); !errors.Is(err, boom) {
+        t.Fatalf("expected Wait to report the failed task, got %v", err)
+    }
+    if ran != 20 {
+        t.Fatalf("expected 20 tasks to run, got %d", ran)
+    }
+    if maxRunning > 3 {
+        t.Fatalf("expected at most 3 concurrent tasks, got %d", maxRunning)
+    }
+    if err := sched.Wait(); err != nil {
+        t.Fatalf("errors should be reset after Wait, got %v", err)
+    }
+}
+
+func TestSubmitDispatchesByPriority(t *testing.T) {
+    sched := New(1)
+    defer sched.Close()
+
+    release := make(chan struct{})
+    var order []int
+    sched.Submit(Task{Fn: func(context.Context) error {
+        <-release
+        return nil
+    }})
+    for _, p := range []int{1, 3, 2, 3} {
+        priority := p
+        sched.Submit(Task{Priority: priority, Fn: func(context.Context) error {
+            order = append(order, priority)
+            return nil
+        }})
+    }
+    close(release)
+
+    if err := sched.Wait(); err != nil {
+        t.Fatalf("wait returned error: %v", err)
+    }
+    expected := []int{3, 3, 2, 1}
+    for i := range expected {
+        if order[i] != expected[i] {
+            t.Fatalf("expected order %v, got %v", expected, order)
+        }
+    }
+}
+
+func TestCloseCancelsRunningAndRejectsSubmit(t *testing.T) {
+    sched := New(1)
+    started := make(chan struct{})
+    sched.Submit(Task{Fn: func(ctx context.Context) error {
+        close(started)
+        <-ctx.Done()
+        return ctx.Err()
+    }})
+    sched.Submit(Task{Fn: func(context.Context) error {
+        t.Errorf("queued task should be discarded by Close")
+        return nil
+    }})
+
+    <-started
+    sched.Close()
+    if err := sched.Wait(); !errors.Is(err, context.Canceled) {
+        t.Fatalf("expected running task to be cancelled, got %v", err)
+    }
+    if _, err := sched.Submit(Task{Fn: func(context.Context) error { return nil }}); !errors.Is(err, ErrClosed) {
+        t.Fatalf("expected ErrClosed, got %v", err)
+    }
+}
+
+func TestHooksDoNotBlockDispatch(t *testing.T) {
+    slow := make(chan struct{})
+    var mu sync.Mutex
+    completed := 0
+    sched := New(2, WithOnComplete(func(task Task, err error, elapsed time.Duration) {
+        if task.Priority == 1 {
+            // Stall the first task's hook; the other worker keeps going.
+            <-slow
+        }
+        mu.Lock()
+        completed++
+        mu.Unlock()
+    }))
+    defer sched.Close()
+
+    sched.Submit(Task{Priority: 1, Fn: func(context.Context) error { return nil }})
+    for i := 0; i < 5; i++ {
+        sched.Submit(Task{Fn: func(context.Context) error { return nil }})
+    }
+
+    deadline := time.Now().Add(time.Second)
+    for {
+        mu.Lock()
+        n := completed
+        mu.Unlock()
+        if n == 5 {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("a slow hook stalled dispatch of other tasks")
+        }
+        time.Sleep(time.Millisecond)
+    }
+    close(slow)
+    if err := sched.Wait(); err != nil {
+        t.Fatalf("wait returned error: %v", err)
+    }
+    if completed != 6 {
+        t.Fatalf("expected 6 completion hooks, got %d", completed)
+    }
+}
+
+func TestStatsTrackTaskStates(t *testing.T) {
+    const limit = 3
+    sched := New(limit)
+    defer sched.Close()
+
+    stop := make(chan struct{})
+    sampled := make(chan int64)
+    go func() {
+        var maxRunning int64
+        for {
+            select {
+            case <-stop:
+                sampled <- maxRunning
+                return
+            default:
+            }
+            if r := sched.Stats().Running; r > maxRunning {
+                maxRunning = r
+            }
+        }
+    }()
+
+    boom := errors.New("boom")
+    for i := 0; i < 30; i++ {
+        idx := i
+        sched.Submit(Task{Fn: func(context.Context) error {
+            if idx%3 == 0 {
+                time.Sleep(5 * time.Millisecond)
+            }
+            if idx%10 == 0 {
+                return boom
+            }
+            return nil
+        }})
+    }
+    if st := sched.Stats(); st.Submitted != 30 {
+        t.Fatalf("expected 30 submitted tasks, got %+v", st)
+    }
+    sched.Wait()
+    close(stop)
+
+    if maxRunning := <-sampled; maxRunning > limit {
+        t.Fatalf("sampled %d running tasks, limit is %d", maxRunning, limit)
+    }
+    st := sched.Stats()
+    want := Stats{Submitted: 30, Completed: 27, Failed: 3}
+    if st != want {
+        t.Fatalf("expected %+v, got %+v", want, st)
+    }
+
+    sched.RunAll(context.Background(), []Task{
+        {Fn: func(context.Context) error { return boom }},
+        {Fn: func(context.Context) error { return nil }, DependsOn: []int{0}},
+    })
+    want = Stats{Submitted: 32, Completed: 27, Failed: 4}
+    if st := sched.Stats(); st != want {
+        t.Fatalf("expected skipped task to leave the queue, want %+v, got %+v", want, st)
+    }
+}
+
+func TestSubmitHoldsDelayedTasks(t *testing.T) {
+    clock := newFakeClock()
+    sched := New(2, WithClock(clock))
+    defer sched.Close()
+
+    ran := make(chan string, 3)
+    sched.Submit(Task{NotBefore: clock.Now().Add(time.Minute), Fn: func(context.Context) error {
+        ran <- "delayed"
+        return nil
+    }})
+    sched.Submit(Task{Fn: func(context.Context) error {
+        ran <- "immediate"
+        return nil
+    }})
+
+    if got := <-ran; got != "immediate" {
+        t.Fatalf("expected the undelayed task first, got %s", got)
+    }
+    clock.BlockUntil(1)
+    clock.Advance(30 * time.Second)
+    select {
+    case got := <-ran:
+        t.Fatalf("%s ran before its NotBefore", got)
+    case <-time.After(10 * time.Millisecond):
+    }
+
+    clock.Advance(30 * time.Second)
+    if err := sched.Wait(); err != nil {
+        t.Fatalf("wait returned error: %v", err)
+    }
+    if got := <-ran; got != "delayed" {
+        t.Fatalf("expected the delayed task to run, got %s", got)
+    }
+}
+
+func TestAgingLetsOldTasksOutrankNewOnes(t *testing.T) {
+    for _, tc := range []struct {
+        name  string
+        opts  []Option
+        first string
+    }{
+        {name: "strict", first: "fresh-high"},
+        {name: "aging", opts: []Option{WithAging(1, time.Second)}, first: "aged-low"},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            clock := newFakeClock()
+            sched := New(1, append([]Option{WithClock(clock)}, tc.opts...)...)
+            defer sched.Close()
+
+            release := make(chan struct{})
+            sched.Submit(Task{Fn: func(context.Context) error {
+                <-release
+                return nil
+            }})
+
+            var order []string
+            record := func(name string) func(context.Context) error {
+                return func(context.Context) error {
+                    order = append(order, name)
+                    return nil
+                }
+            }
+            sched.Submit(Task{Priority: 0, Fn: record("aged-low")})
+            clock.Advance(10 * time.Second)
+            sched.Submit(Task{Priority: 5, Fn: record("fresh-high")})
+            close(release)
+
+            if err := sched.Wait(); err != nil {
+                t.Fatalf("wait returned error: %v", err)
+            }
+            if order[0] != tc.first {
+                t.Fatalf("expected %s to run first, got %v", tc.first, order)
+            }
+        })
+    }
+}
+
+func TestDrainFinishesQueuedWorkAndRejectsSubmit(t *testing.T) {
+    sched := New(1)
+    release := make(chan struct{})
+    var mu sync.Mutex
+    ran := 0
+    for i := 0; i < 4; i++ {
+        sched.Submit(Task{Fn: func(ctx context.Context) error {
+            <-release
+            mu.Lock()
+            ran++
+            mu.Unlock()
+            return ctx.Err()
+        }})
+    }
+
+    drained := make(chan error, 1)
+    go func() { drained <- sched.Drain(context.Background()) }()
+
+    // Submit is rejected as soon as draining begins, even with work queued.
+    deadline := time.Now().Add(time.Second)
+    for {
+        if _, err := sched.Submit(Task{Fn: func(context.Context) error { return nil }}); err != nil {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("submit should fail with ErrClosed while draining")
+        }
+        time.Sleep(time.Millisecond)
+    }
+    close(release)
+
+    if err := <-drained; err != nil {
+        t.Fatalf("drain returned error: %v", err)
+    }
+    if ran != 4 {
+        t.Fatalf("expected all queued tasks to finish uncancelled, got %d", ran)
+    }
+    if _, err := sched.Submit(Task{Fn: func(context.Context) error { return nil }}); !errors.Is(err, ErrClosed) {
+        t.Fatalf("expected ErrClosed after drain, got %v", err)
+    }
+}
+
+func TestDrainGivesUpWhenContextEnds(t *testing.T) {
+    sched := New(1)
+    defer sched.Close()
+    release := make(chan struct{})
+    defer close(release)
+    sched.Submit(Task{Fn: func(context.Context) error {
+        <-release
+        return nil
+    }})
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    if err := sched.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("expected DeadlineExceeded, got %v", err)
+    }
+    if _, err := sched.Submit(Task{Fn: func(context.Context) error { return nil }}); !errors.Is(err, ErrClosed) {
+        t.Fatalf("expected ErrClosed after an interrupted drain, got %v", err)
+    }
+}
+
+func TestSetLimitResizesPool(t *testing.T) {
+    sched := New(1)
+    defer sched.Close()
+
+    release := make(chan struct{})
+    for i := 0; i < 6; i++ {
+        sched.Submit(Task{Fn: func(context.Context) error {
+            <-release
+            return nil
+        }})
+    }
+    waitForRunning(t, sched, 1)
+
+    sched.SetLimit(3)
+    waitForRunning(t, sched, 3)
+
+    sched.SetLimit(1)
+    // Running tasks are allowed to finish; with two done, one slot remains
+    // above the new limit so nothing new may start.
+    release <- struct{}{}
+    release <- struct{}{}
+    waitForRunning(t, sched, 1)
+    time.Sleep(10 * time.Millisecond)
+    if st := sched.Stats(); st.Running != 1 || st.Queued != 3 {
+        t.Fatalf("expected one running and three queued after shrinking, got %+v", st)
+    }
+
+    close(release)
+    if err := sched.Wait(); err != nil {
+        t.Fatalf("wait returned error: %v", err)
+    }
+}
+
+func TestSetLimitAppliesToRunInProgress(t *testing.T) {
+    sched := New(1)
+    release := make(chan struct{})
+    tasks := make([]Task, 4)
+    for i := range tasks {
+        tasks[i] = Task{Fn: func(context.Context) error {
+            <-release
+            return nil
+        }}
+    }
+
+    errc := make(chan error, 1)
+    go func() { errc <- sched.Run(context.Background(), tasks) }()
+    waitForRunning(t, sched, 1)
+    sched.SetLimit(4)
+    waitForRunning(t, sched, 4)
+    close(release)
+    if err := <-errc; err != nil {
+        t.Fatalf("run returned error: %v", err)
+    }
+
+    defer func() {
+        if recover() == nil {
+            t.Fatalf("expected panic on non-positive limit")
+        }
+    }()
+    sched.SetLimit(0)
+}
+
+// waitForRunning polls Stats until exactly n tasks are running.
+func waitForRunning(t *testing.T, sched *Scheduler, n int64) {
+    t.Helper()
+    deadline := time.Now().Add(time.Second)
+    for sched.Stats().Running != n {
+        if time.Now().After(deadline) {
+            t.Fatalf("expected %d running tasks, got %+v", n, sched.Stats())
+        }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+func TestHandleCancelStopsOnlyThatTask(t *testing.T) {
+    sched := New(2)
+    defer sched.Close()
+
+    started := make(chan struct{})
+    target, _ := sched.Submit(Task{Fn: func(ctx context.Context) error {
+        close(started)
+        <-ctx.Done()
+        return ctx.Err()
+    }})
+    release := make(chan struct{})
+    other, _ := sched.Submit(Task{Fn: func(ctx context.Context) error {
+        <-release
+        return ctx.Err()
+    }})
+
+    <-started
+    target.Cancel()
+    <-target.Done()
+    if !errors.Is(target.Err(), context.Canceled) {
+        t.Fatalf("expected context.Canceled, got %v", target.Err())
+    }
+    if other.Err() != nil {
+        t.Fatalf("other task should still be running, got %v", other.Err())
+    }
+    close(release)
+    <-other.Done()
+    if other.Err() != nil {
+        t.Fatalf("other task should be unaffected, got %v", other.Err())
+    }
+    target.Cancel()
+    if !errors.Is(target.Err(), context.Canceled) {
+        t.Fatalf("cancelling a finished task should change nothing, got %v", target.Err())
+    }
+}
+
+func TestHandleCancelBeforeStartSkipsTask(t *testing.T) {
+    sched := New(1)
+    defer sched.Close()
+
+    release := make(chan struct{})
+    sched.Submit(Task{Fn: func(context.Context) error {
+        <-release
+        return nil
+    }})
+    queued, _ := sched.Submit(Task{Fn: func(context.Context) error {
+        t.Errorf("cancelled task should not run")
+        return nil
+    }})
+    queued.Cancel()
+    close(release)
+
+    if err := sched.Wait(); !errors.Is(err, context.Canceled) {
+        t.Fatalf("expected Wait to report the cancelled task, got %v", err)
+    }
+    if !errors.Is(queued.Err(), context.Canceled) {
+        t.Fatalf("expected context.Canceled, got %v", queued.Err())
+    }
+}
+
+func TestHandleCancelDiscardsDelayedTaskAtOnce(t *testing.T) {
+    sched := New(1)
+    defer sched.Close()
+
+    later, _ := sched.Submit(Task{NotBefore: time.Now().Add(time.Hour), Fn: func(context.Context) error {
+        t.Errorf("cancelled task should not run")
+        return nil
+    }})
+    other, _ := sched.Submit(Task{NotBefore: time.Now().Add(time.Hour), Fn: func(context.Context) error { return nil }})
+    if later.Position() != 0 || other.Position() != 1 {
+        t.Fatalf("expected positions 0 and 1, got %d and %d", later.Position(), other.Position())
+    }
+    later.Cancel()
+
+    select {
+    case <-later.Done():
+    default:
+        t.Fatalf("expected Done to be closed as soon as the task is cancelled")
+    }
+    if !errors.Is(later.Err(), context.Canceled) {
+        t.Fatalf("expected context.Canceled, got %v", later.Err())
+    }
+    if later.Position() != -1 || other.Position() != 0 {
+        t.Fatalf("expected the cancelled task to leave the queue, got positions %d and %d", later.Position(), other.Position())
+    }
+    other.Cancel()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+    defer cancel()
+    if err := sched.Drain(ctx); !errors.Is(err, context.Canceled) {
+        t.Fatalf("expected Drain to finish and report the cancellations, got %v", err)
+    }
+}
+
+func TestRateLimiterPacesSubmittedTasks(t *testing.T) {
+    clock := newFakeClock()
+    sched := New(4, WithClock(clock), WithRateLimiter(&intervalLimiter{every: time.Minute}, time.Minute))
+    defer sched.Close()
+
+    var started atomic.Int64
+    for i := 0; i < 3; i++ {
+        sched.Submit(Task{Fn: func(context.Context) error {
+            started.Add(1)
+            return nil
+        }})
+    }
+
+    clock.BlockUntil(1)
+    waitFor(t, func() bool { return started.Load() == 1 })
+    clock.Advance(time.Minute)
+    clock.BlockUntil(1)
+    waitFor(t, func() bool { return started.Load() == 2 })
+    clock.Advance(time.Minute)
+    if err := sched.Wait(); err != nil {
+        t.Fatalf("wait returned error: %v", err)
+    }
+    if got := started.Load(); got != 3 {
+        t.Fatalf("expected 3 tasks to start, got %d", got)
+    }
+}
+
+func TestRunGroupCancelsOnFirstError(t *testing.T) {
+    sched := New(2)
+    defer sched.Close()
+    boom := errors.New("boom")
+    var sawCancel, lateRan atomic.Bool
+
+    submit, wait := sched.RunGroup(context.Background())
+    submit(Task{Priority: 9, Fn: func(context.Context) error {
+        time.Sleep(10 * time.Millisecond)
+        return boom
+    }})
+    submit(Task{Priority: 9, Fn: func(ctx context.Context) error {
+        select {
+        case <-ctx.Done():
+            sawCancel.Store(true)
+            return ctx.Err()
+        case <-time.After(time.Second):
+            return nil
+        }
+    }})
+    // Queued behind the two above, so the failure comes first.
+    submit(Task{Priority: 0, Fn: func(context.Context) error {
+        lateRan.Store(true)
+        return nil
+    }})
+
+    if err := wait(); err != boom {
+        t.Fatalf("expected the first error, got %v", err)
+    }
+    if !sawCancel.Load() {
+        t.Fatalf("expected the running task to see its context cancelled")
+    }
+    if lateRan.Load() {
+        t.Fatalf("expected the queued task to be discarded after the failure")
+    }
+
+    submit, wait = sched.RunGroup(context.Background())
+    for i := 0; i < 5; i++ {
+        submit(Task{Fn: func(context.Context) error { return nil }})
+    }
+    if err := wait(); err != nil {
+        t.Fatalf("expected nil when nothing fails, got %v", err)
+    }
+}
+
+func TestRunGroupTasksSeeParentContext(t *testing.T) {
+    type key string
+    sched := New(2)
+    defer sched.Close()
+    parent, cancel := context.WithTimeout(context.WithValue(context.Background(), key("trace"), "abc"), 30*time.Millisecond)
+    defer cancel()
+
+    var trace, tenant atomic.Value
+    submit, wait := sched.RunGroup(parent)
+    submit(Task{Fn: func(ctx context.Context) error {
+        trace.Store(ctx.Value(key("trace")))
+        tenant.Store(ctx.Value(key("tenant")))
+        <-ctx.Done()
+        return ctx.Err()
+    }}.WithValue(key("tenant"), "t1"))
+
+    if err := wait(); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("expected the parent's deadline to surface as DeadlineExceeded, got %v", err)
+    }
+    if trace.Load() != "abc" || tenant.Load() != "t1" {
+        t.Fatalf("expected the parent's and the task's values, got %v and %v", trace.Load(), tenant.Load())
+    }
+}
+
+func TestRunGroupKeepsTaskTimeoutAsDeadline(t *testing.T) {
+    sched := New(1)
+    defer sched.Close()
+    submit, wait := sched.RunGroup(context.Background())
+    submit(Task{Timeout: 20 * time.Millisecond, Fn: func(ctx context.Context) error {
+        <-ctx.Done()
+        return ctx.Err()
+    }})
+    if err := wait(); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("expected a timed-out group task to report DeadlineExceeded, got %v", err)
+    }
+}
+
+func TestRunGroupWaitDoesNotBlockOnDelayedTasks(t *testing.T) {
+    sched := New(2)
+    defer sched.Close()
+    boom := errors.New("boom")
+
+    submit, wait := sched.RunGroup(context.Background())
+    submit(Task{NotBefore: time.Now().Add(time.Hour), Fn: func(context.Context) error {
+        t.Errorf("delayed task should be discarded after the failure")
+        return nil
+    }})
+    submit(Task{Fn: func(context.Context) error { return boom }})
+
+    errc := make(chan error, 1)
+    go func() { errc <- wait() }()
+    select {
+    case err := <-errc:
+        if err != boom {
+            t.Fatalf("expected the first error, got %v", err)
+        }
+    case <-time.After(time.Second):
+        t.Fatalf("wait still blocked on the delayed task after the first error")
+    }
+}
+
+func TestHandlePositionAdvancesAsTasksStart(t *testing.T) {
+    clock := newFakeClock()
+    sched := New(1, WithClock(clock))
+    defer sched.Close()
+
+    blocking := func(release chan struct{}) Task {
+        return Task{Fn: func(context.Context) error {
+            <-release
+            return nil
+        }}
+    }
+    first := make(chan struct{})
+    sched.Submit(blocking(first))
+    waitForRunning(t, sched, 1)
+
+    releases := make([]chan struct{}, 3)
+    for i := range releases {
+        releases[i] = make(chan struct{})
+        task := blocking(releases[i])
+        task.Priority = 3 - i
+        sched.Submit(task)
+    }
+    target, _ := sched.Submit(Task{Fn: func(context.Context) error { return nil }})
+    if got := target.Position(); got != 3 {
+        t.Fatalf("expected position 3 behind higher priorities, got %d", got)
+    }
+
+    clock.Advance(10 * time.Second)
+    close(first)
+    waitFor(t, func() bool { return target.Position() == 2 })
+    // One running and two queued ahead, each taking the 10s average.
+    if got, want := target.EstimatedStart(), clock.Now().Add(30*time.Second); !got.Equal(want) {
+        t.Fatalf("expected estimated start %v, got %v", want, got)
+    }
+
+    for i, release := range releases {
+        close(release)
+        want := 1 - i
+        waitFor(t, func() bool { return target.Position() == want })
+    }
+    if err := sched.Wait(); err != nil {
+        t.Fatalf("wait returned error: %v", err)
+    }
+    if got := target.EstimatedStart(); !got.IsZero() {
+        t.Fatalf("expected zero estimate once started, got %v", got)
+    }
+}
+
+func TestHandlePositionUnderFairShare(t *testing.T) {
+    sched := New(1, WithPolicy(PolicyFairShare))
+    defer sched.Close()
+
+    release := make(chan struct{})
+    sched.Submit(Task{Fn: func(context.Context) error {
+        <-release
+        return nil
+    }})
+    waitForRunning(t, sched, 1)
+
+    var handles []*Handle
+    for _, g := range []string{"a", "a", "a", "b", "c", "c"} {
+        h, _ := sched.Submit(Task{Group: g, Fn: func(context.Context) error { return nil }})
+        handles = append(handles, h)
+    }
+    // Dispatch order is a b c a c a.
+    want := []int{0, 3, 5, 1, 2, 4}
+    for i, h := range handles {
+        if got := h.Position(); got != want[i] {
+            t.Errorf("task %d: expected position %d, got %d", i, want[i], got)
+        }
+    }
+    close(release)
+    if err := sched.Wait(); err != nil {
+        t.Fatalf("wait returned error: %v", err)
+    }
+}
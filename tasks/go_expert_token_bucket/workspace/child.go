@@ -0,0 +1,78 @@
+package tokenbucket
+
+import "time"
+
+// Level identifies which bucket of a ChildBucket refused a request.
+type Level int
+
+const (
+    // LevelNone means no bucket refused: the request was granted.
+    LevelNone Level = iota
+    // LevelChild is the child's own bucket, such as a per-tenant quota.
+    LevelChild
+    // LevelParent is the parent bucket shared between children, such as a
+    // global quota.
+    LevelParent
+)
+
+// ChildBucket is a bucket of its own that also draws on a parent TokenBucket
+// shared with other children, modelling a two-level quota such as a
+// per-tenant limit under a global one. A request is granted only if both
+// buckets hold the tokens, and then both are spent together.
+type ChildBucket struct {
+    bucket *TokenBucket
+    parent *TokenBucket
+}
+
+// NewChildBucket creates a full child of parent holding capacity tokens at
+// start and regaining refillRate tokens per second, like NewTokenBucket.
+func NewChildBucket(parent *TokenBucket, capacity int, refillRate float64, start time.Time) *ChildBucket {
+    return &ChildBucket{bucket: NewTokenBucket(capacity, refillRate, start), parent: parent}
+}
+
+// Allow spends tokens from both the child and its parent at the given time,
+// or from neither if either holds too few.
+func (c *ChildBucket) Allow(at time.Time, tokens int) bool {
+    ok, _ := c.AllowWithReason(at, tokens)
+    return ok
+}
+
+// AllowWithReason is Allow that also reports which level refused the
+// request, the child being checked first; denied is LevelNone on success.
+// Non-positive requests are refused at LevelChild. Both buckets are locked
+// for the whole check, so no caller ever sees tokens
+// spent from one bucket and given back later.
+func (c *ChildBucket) AllowWithReason(at time.Time, tokens int) (ok bool, denied Level) {
+    if tokens <= 0 {
+        return false, LevelChild
+    }
+    need := float64(tokens)
+    // The child's bucket is never anyone's parent, so taking its lock
+    // before the parent's cannot deadlock.
+    c.bucket.mu.Lock()
+    c.parent.mu.Lock()
+    c.bucket.refill(at)
+    c.parent.refill(at)
+    switch {
+    case need > c.bucket.tokens:
+        denied = LevelChild
+    case need > c.parent.tokens:
+        denied = LevelParent
+    default:
+        c.bucket.tokens -= need
+        c.parent.tokens -= need
+        ok = true
+    }
+    c.parent.mu.Unlock()
+    c.bucket.mu.Unlock()
+    if denied != LevelChild {
+        c.parent.stats.record(tokens, ok)
+    }
+    return ok, denied
+}
+
+// Tokens returns the child's own balance at the given time, ignoring the
+// parent's, like TokenBucket.Tokens.
+func (c *ChildBucket) Tokens(at time.Time) float64 {
+    return c.bucket.Tokens(at)
+}
@@ -0,0 +1,94 @@
+package tokenbucket
+
+import (
+    "context"
+    "math"
+    "math/rand"
+    "time"
+)
+
+// SetJitterSource makes WaitJitter draw its random delays from src instead
+// of math/rand's global source, so tests can fix the sequence. src need not
+// be safe for concurrent use; the bucket serialises access to it.
+func (b *TokenBucket) SetJitterSource(src rand.Source) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.jitterRand = rand.New(src)
+}
+
+// WaitJitter is Wait with a random extra delay of up to maxJitter added
+// whenever the caller has to wait, so that clients throttled at the same
+// moment do not all retry in lockstep. The tokens are reserved exactly as by
+// Wait and the jitter only ever lengthens the sleep, so it never lets tokens
+// be used before they are available. A caller that need not wait returns at
+// once, and a maxJitter of zero or less makes WaitJitter behave as Wait. As
+// with Wait, if ctx is done first nothing is spent, even if the tokens had
+// already refilled and only the jitter remained.
+func (b *TokenBucket) WaitJitter(ctx context.Context, at time.Time, tokens int, maxJitter time.Duration) error {
+    if tokens <= 0 {
+        return ErrInvalidTokens
+    }
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    begin := time.Now()
+    r := b.Reserve(at, tokens)
+    if !r.OK() {
+        return ErrExceedsCapacity
+    }
+    delay := r.Delay()
+    extra := b.jittered(delay, maxJitter) - delay
+    if err := r.sleep(ctx, at, delay); err != nil || extra == 0 {
+        return err
+    }
+    // The reservation is now due, so Cancel would keep its tokens.
+    timer := time.NewTimer(extra)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        r.giveBack(at.Add(time.Since(begin)))
+        return ctx.Err()
+    }
+}
+
+// giveBack returns all of r's tokens to the bucket as of at, for a caller
+// that gives up on r after it fell due but before acting on it. Unlike
+// Cancel it does not hold back tokens for later reservations, which were
+// made on the assumption that these tokens were spent and so still hold.
+func (r *Reservation) giveBack(at time.Time) {
+    b := r.bucket
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if r.cancelled {
+        return
+    }
+    r.cancelled = true
+    b.refill(at)
+    b.tokens = math.Min(b.tokens+r.tokens, b.capacity)
+}
+
+// jittered returns delay plus a uniformly random duration in [0, maxJitter],
+// or delay itself if it or maxJitter is not positive.
+func (b *TokenBucket) jittered(delay, maxJitter time.Duration) time.Duration {
+    if delay <= 0 || maxJitter <= 0 {
+        return delay
+    }
+    n := int64(maxJitter)
+    if n < math.MaxInt64 {
+        n++
+    }
+    b.mu.Lock()
+    var extra int64
+    if b.jitterRand != nil {
+        extra = b.jitterRand.Int63n(n)
+    } else {
+        extra = rand.Int63n(n)
+    }
+    b.mu.Unlock()
+    if delay > InfDuration-time.Duration(extra) {
+        return InfDuration
+    }
+    return delay + time.Duration(extra)
+}
@@ -0,0 +1,64 @@
+package tokenbucket
+
+import (
+    "sync"
+    "time"
+)
+
+// LeakyBucket is the leaky bucket as a meter: admitted requests fill a
+// virtual queue that drains at the leak rate, and a request is admitted only
+// if the queue has room for it. Requests are never delayed, only admitted or
+// refused, which makes it equivalent to a TokenBucket of capacity size
+// refilling at leakRate, whose balance is size minus the queue's depth; it
+// offers the same limit measured from the other side.
+type LeakyBucket struct {
+    mu       sync.Mutex
+    size     float64
+    leakRate float64 // units drained per second
+    level    float64
+    last     time.Time
+}
+
+// NewLeakyBucket creates an empty bucket at start whose queue holds size
+// units and drains leakRate units per second.
+func NewLeakyBucket(size int, leakRate float64, start time.Time) *LeakyBucket {
+    return &LeakyBucket{size: float64(size), leakRate: leakRate, last: start}
+}
+
+// Allow adds n units to the queue at the given time if they fit, and
+// otherwise leaves the queue untouched and returns false. Requests for zero
+// or fewer units are rejected.
+func (b *LeakyBucket) Allow(at time.Time, n int) bool {
+    if n <= 0 {
+        return false
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.drain(at)
+    if b.level+float64(n) > b.size {
+        return false
+    }
+    b.level += float64(n)
+    return true
+}
+
+// Depth returns how many units are queued at the given time, including any
+// fraction not yet drained.
+func (b *LeakyBucket) Depth(at time.Time) float64 {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.drain(at)
+    return b.level
+}
+
+// drain removes what has leaked out since the last drain. b.mu must be held.
+func (b *LeakyBucket) drain(at time.Time) {
+    if !at.After(b.last) {
+        return
+    }
+    b.level -= at.Sub(b.last).Seconds() * b.leakRate
+    b.last = at
+    if b.level < 0 {
+        b.level = 0
+    }
+}
@@ -0,0 +1,90 @@
+package tokenbucket
+
+import (
+    "sync"
+    "time"
+)
+
+// Limiter keeps a separate TokenBucket for each key, such as a client ID,
+// creating buckets on first use with shared settings.
+type Limiter struct {
+    mu         sync.Mutex
+    capacity   int
+    refillRate float64
+    idleTTL    time.Duration
+    buckets    map[string]*keyedBucket
+    nextPrune  time.Time
+}
+
+// keyedBucket is a Limiter's bucket for one key and when it was last used.
+type keyedBucket struct {
+    bucket   *TokenBucket
+    lastUsed time.Time
+}
+
+// NewLimiter creates a Limiter whose buckets hold capacity tokens and refill
+// at refillRate tokens per second. Buckets unused for idleTTL are forgotten;
+// a key that returns afterwards starts again with a full bucket, so an idleTTL
+// no shorter than capacity/refillRate seconds loses nothing, because its
+// bucket would have refilled by then anyway. An idleTTL of zero or less keeps
+// buckets forever.
+func NewLimiter(capacity int, refillRate float64, idleTTL time.Duration) *Limiter {
+    return &Limiter{
+        capacity:   capacity,
+        refillRate: refillRate,
+        idleTTL:    idleTTL,
+        buckets:    make(map[string]*keyedBucket),
+    }
+}
+
+// AllowKey spends tokens from key's bucket at the given time, creating the
+// bucket full if key has not been seen or has been forgotten. It reports the
+// result of the bucket's Allow.
+func (l *Limiter) AllowKey(key string, at time.Time, tokens int) bool {
+    l.mu.Lock()
+    if l.idleTTL > 0 && !at.Before(l.nextPrune) {
+        l.prune(at)
+        l.nextPrune = at.Add(l.idleTTL)
+    }
+    kb, ok := l.buckets[key]
+    if !ok {
+        kb = &keyedBucket{bucket: NewTokenBucket(l.capacity, l.refillRate, at)}
+        l.buckets[key] = kb
+    }
+    if at.After(kb.lastUsed) {
+        kb.lastUsed = at
+    }
+    l.mu.Unlock()
+    return kb.bucket.Allow(at, tokens)
+}
+
+// Prune forgets every bucket unused for the idle TTL as of the given time and
+// returns how many it removed. AllowKey prunes on its own about once per idle
+// TTL, so calling Prune is only needed to reclaim memory sooner.
+func (l *Limiter) Prune(at time.Time) int {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.idleTTL <= 0 {
+        return 0
+    }
+    return l.prune(at)
+}
+
+// Len returns the number of buckets the Limiter currently holds.
+func (l *Limiter) Len() int {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return len(l.buckets)
+}
+
+// prune implements Prune. l.mu must be held.
+func (l *Limiter) prune(at time.Time) int {
+    n := 0
+    for key, kb := range l.buckets {
+        if at.Sub(kb.lastUsed) >= l.idleTTL {
+            delete(l.buckets, key)
+            n++
+        }
+    }
+    return n
+}
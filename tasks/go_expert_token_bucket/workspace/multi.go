@@ -0,0 +1,87 @@
+package tokenbucket
+
+import (
+    "slices"
+    "time"
+)
+
+// MultiLimiter enforces several TokenBuckets together, such as a per-second
+// and a per-minute limit: a request is granted only if every bucket grants
+// it.
+type MultiLimiter struct {
+    buckets []*TokenBucket
+    locking []*TokenBucket // distinct buckets in the order they are locked
+}
+
+// NewMultiLimiter combines buckets. They are consulted in the order given.
+func NewMultiLimiter(buckets ...*TokenBucket) *MultiLimiter {
+    locking := slices.Clone(buckets)
+    // Locking in creation order keeps limiters that share buckets from
+    // deadlocking, whatever order each was given them in.
+    slices.SortFunc(locking, func(a, b *TokenBucket) int {
+        switch {
+        case a.id < b.id:
+            return -1
+        case a.id > b.id:
+            return 1
+        }
+        return 0
+    })
+    return &MultiLimiter{buckets: buckets, locking: slices.Compact(locking)}
+}
+
+// Allow spends tokens from every bucket at the given time, or from none of
+// them if any bucket refuses.
+func (m *MultiLimiter) Allow(at time.Time, tokens int) bool {
+    ok, _ := m.AllowWithReason(at, tokens)
+    return ok
+}
+
+// AllowWithReason is Allow that also reports, when the request is refused,
+// the index of the first bucket that refused it; denied is -1 on success.
+// Every bucket is locked for the whole check, so the request is granted or
+// refused as one: no concurrent caller ever sees some of its tokens spent, and
+// each bucket's Stats count a grant only when every bucket granted it. A
+// bucket listed more than once is charged once per listing.
+func (m *MultiLimiter) AllowWithReason(at time.Time, tokens int) (ok bool, denied int) {
+    if len(m.buckets) == 0 {
+        return true, -1
+    }
+    if tokens <= 0 {
+        m.buckets[0].stats.record(tokens, false)
+        return false, 0
+    }
+    for _, b := range m.locking {
+        b.mu.Lock()
+        b.refill(at)
+    }
+    denied = -1
+    for i, b := range m.buckets {
+        need := float64(tokens)
+        for _, earlier := range m.buckets[:i] {
+            if earlier == b {
+                need += float64(tokens)
+            }
+        }
+        if need > b.tokens {
+            denied = i
+            break
+        }
+    }
+    if denied < 0 {
+        for _, b := range m.buckets {
+            b.tokens -= float64(tokens)
+        }
+    }
+    for _, b := range m.locking {
+        b.mu.Unlock()
+    }
+    if denied >= 0 {
+        m.buckets[denied].stats.record(tokens, false)
+        return false, denied
+    }
+    for _, b := range m.buckets {
+        b.stats.record(tokens, true)
+    }
+    return true, -1
+}
@@ -0,0 +1,41 @@
+package tokenbucket
+
+import (
+    "encoding/json"
+    "time"
+)
+
+// State is the part of a TokenBucket's state that changes as it is used:
+// its balance as of the last time it refilled. Capacity and refill rate are
+// configuration and are not included.
+type State struct {
+    Tokens     float64   `json:"tokens"`
+    LastRefill time.Time `json:"last_refill"`
+}
+
+// MarshalState encodes the bucket's State as JSON.
+func (b *TokenBucket) MarshalState() ([]byte, error) {
+    b.mu.Lock()
+    s := State{Tokens: b.tokens, LastRefill: b.last}
+    b.mu.Unlock()
+    return json.Marshal(s)
+}
+
+// RestoreState replaces the bucket's State with one encoded by MarshalState,
+// clamping the balance to the bucket's capacity. Later calls refill from the
+// restored time, so the bucket carries on as if it had never been stopped.
+func (b *TokenBucket) RestoreState(data []byte) error {
+    var s State
+    if err := json.Unmarshal(data, &s); err != nil {
+        return err
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.tokens = s.Tokens
+    if b.tokens > b.capacity {
+        b.tokens = b.capacity
+    }
+    b.last = s.LastRefill
+    b.lastEvent = s.LastRefill
+    return nil
+}
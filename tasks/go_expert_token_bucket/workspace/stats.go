@@ -0,0 +1,44 @@
+package tokenbucket
+
+import "sync/atomic"
+
+// Stats is a snapshot of a TokenBucket's cumulative Allow counters.
+type Stats struct {
+    // GrantedTokens is the total number of tokens spent by granted requests.
+    GrantedTokens int64
+    // GrantedRequests counts Allow calls that returned true.
+    GrantedRequests int64
+    // ThrottledRequests counts Allow calls that returned false.
+    ThrottledRequests int64
+}
+
+// counters holds the live values behind Stats. They are updated outside the
+// bucket's lock so reading them never contends with Allow.
+type counters struct {
+    grantedTokens     atomic.Int64
+    grantedRequests   atomic.Int64
+    throttledRequests atomic.Int64
+}
+
+// record counts the outcome of an Allow call.
+func (c *counters) record(tokens int, granted bool) {
+    if granted {
+        c.grantedTokens.Add(int64(tokens))
+        c.grantedRequests.Add(1)
+    } else {
+        c.throttledRequests.Add(1)
+    }
+}
+
+// Stats returns a snapshot of the bucket's counters. They cover Allow and
+// AllowNow, including calls made on the bucket's behalf by a Limiter or a
+// MultiLimiter, and requests a ChildBucket passes on to it as a parent. Each
+// counter is read atomically, but the snapshot as a whole may straddle a
+// concurrent call.
+func (b *TokenBucket) Stats() Stats {
+    return Stats{
+        GrantedTokens:     b.stats.grantedTokens.Load(),
+        GrantedRequests:   b.stats.grantedRequests.Load(),
+        ThrottledRequests: b.stats.throttledRequests.Load(),
+    }
+}
@@ -1,22 +1,398 @@
 package tokenbucket
 
 import (
+    "context"
+    "errors"
+    "math"
+    "math/rand"
     "sync"
+    "sync/atomic"
     "time"
 )
 
+var (
+    // ErrInvalidTokens is returned when a request asks for zero or fewer tokens.
+    ErrInvalidTokens = errors.New("tokenbucket: tokens must be positive")
+    // ErrExceedsCapacity is returned when a request asks for more tokens than
+    // the bucket can ever hold, or the bucket never refills.
+    ErrExceedsCapacity = errors.New("tokenbucket: request can never be satisfied")
+)
+
 // TokenBucket represents a time-aware token bucket.
 type TokenBucket struct {
-    mu sync.Mutex
-    // participants must fill in appropriate fields.
+    mu         sync.Mutex
+    capacity   float64
+    refillRate float64 // tokens per second
+    tokens     float64
+    last       time.Time // the latest time refill has accounted for
+    lastEvent  time.Time // the latest time a reservation may be acted on
+    clock      Clock
+    stats      counters
+    jitterRand *rand.Rand // nil draws jitter from math/rand's global source
+    id         uint64     // orders locking when a MultiLimiter holds several buckets
 }
 
+// bucketIDs numbers buckets as they are created.
+var bucketIDs atomic.Uint64
+
+// Clock tells the time for the Now variants of a bucket's methods.
+type Clock interface {
+    Now() time.Time
+}
+
+// realClock is the default Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// NewTokenBucket creates a bucket holding capacity tokens at start and
+// regaining refillRate tokens per second up to capacity.
 func NewTokenBucket(capacity int, refillRate float64, start time.Time) *TokenBucket {
-    // TODO: implement
-    panic("not implemented")
+    return &TokenBucket{
+        capacity:   float64(capacity),
+        refillRate: refillRate,
+        tokens:     float64(capacity),
+        last:       start,
+        clock:      realClock{},
+        id:         bucketIDs.Add(1),
+    }
 }
 
+// NewTokenBucketClock creates a full bucket like NewTokenBucket, starting at
+// clock's current time, whose AllowNow and WaitNow read time from clock.
+func NewTokenBucketClock(capacity int, refillRate float64, clock Clock) *TokenBucket {
+    b := NewTokenBucket(capacity, refillRate, clock.Now())
+    b.clock = clock
+    return b
+}
+
+// Allow spends tokens at the given time if the bucket holds enough of them,
+// and otherwise leaves the balance untouched and returns false. Requests for
+// zero or fewer tokens are rejected. Times earlier than one the bucket has
+// already seen accrue nothing, so callers racing with slightly different
+// timestamps cannot mint extra tokens.
 func (b *TokenBucket) Allow(at time.Time, tokens int) bool {
-    // TODO: implement
-    panic("not implemented")
+    if tokens <= 0 {
+        b.stats.record(tokens, false)
+        return false
+    }
+    b.mu.Lock()
+    b.refill(at)
+    ok := b.take(float64(tokens))
+    b.mu.Unlock()
+    b.stats.record(tokens, ok)
+    return ok
+}
+
+// AllowPartial spends as many whole tokens as the bucket holds at the given
+// time, up to tokens, and returns how many it spent. It returns 0 for
+// non-positive requests or an empty bucket.
+func (b *TokenBucket) AllowPartial(at time.Time, tokens int) int {
+    if tokens <= 0 {
+        return 0
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.refill(at)
+    granted := tokens
+    if available := math.Floor(b.tokens); float64(granted) > available {
+        granted = int(math.Max(available, 0))
+    }
+    b.tokens -= float64(granted)
+    return granted
+}
+
+// AllowNow is Allow at the bucket's clock's current time. Buckets created by
+// NewTokenBucket use the time package's clock, so mixing AllowNow with
+// explicit timestamps only makes sense if those come from time.Now too.
+func (b *TokenBucket) AllowNow(tokens int) bool {
+    return b.Allow(b.clock.Now(), tokens)
+}
+
+// WaitNow is Wait at the bucket's clock's current time.
+func (b *TokenBucket) WaitNow(ctx context.Context, tokens int) error {
+    return b.Wait(ctx, b.clock.Now(), tokens)
+}
+
+// Tokens returns the balance at the given time, including any fraction of a
+// token accrued so far, without spending anything. The balance is negative
+// while outstanding reservations are still being paid for.
+func (b *TokenBucket) Tokens(at time.Time) float64 {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.refill(at)
+    return b.tokens
+}
+
+// TimeToNextToken returns how long after the given time the bucket will hold
+// at least one whole token, which is zero if it already does, or InfDuration
+// if it never will. It only reads the balance, leaving it untouched.
+func (b *TokenBucket) TimeToNextToken(at time.Time) time.Duration {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    tokens := b.tokens
+    if at.After(b.last) {
+        tokens = math.Min(tokens+at.Sub(b.last).Seconds()*b.refillRate, b.capacity)
+    }
+    switch {
+    case tokens >= 1:
+        return 0
+    case b.refillRate <= 0 || b.capacity < 1:
+        return InfDuration
+    }
+    nanos := (1 - tokens) / b.refillRate * float64(time.Second)
+    d := time.Duration(nanos)
+    if float64(d) < nanos {
+        d++
+    }
+    return d
+}
+
+// SetRate changes the refill rate as of the given time. Tokens accrued up to
+// then are credited at the old rate first, so nothing already earned is lost.
+func (b *TokenBucket) SetRate(refillRate float64, at time.Time) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.refill(at)
+    b.refillRate = refillRate
+}
+
+// SetCapacity changes the capacity as of the given time, first crediting the
+// tokens accrued up to then and then clamping the balance to the new
+// capacity.
+func (b *TokenBucket) SetCapacity(capacity int, at time.Time) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.refill(at)
+    b.capacity = float64(capacity)
+    if b.tokens > b.capacity {
+        b.tokens = b.capacity
+    }
+}
+
+// Reset refills the bucket to capacity as of the given time, discarding any
+// debt left by outstanding reservations. Goroutines holding the bucket keep
+// using it, which makes this safer than replacing it.
+func (b *TokenBucket) Reset(at time.Time) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.tokens = b.capacity
+    b.last = at
+    b.lastEvent = at
+}
+
+// DrainAll empties the bucket as of the given time, first crediting the
+// tokens accrued up to then, so that Allow fails until more refill. Debt left
+// by outstanding reservations is kept rather than forgiven.
+func (b *TokenBucket) DrainAll(at time.Time) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.refill(at)
+    if b.tokens > 0 {
+        b.tokens = 0
+    }
+}
+
+// Wait blocks until tokens can be spent and then spends them. at is taken as
+// the current time; Wait reserves the tokens and sleeps for the delay the
+// refill rate dictates rather than polling. If ctx is done first Wait cancels
+// the reservation and returns ctx.Err() without spending anything. It returns
+// ErrInvalidTokens for non-positive requests and ErrExceedsCapacity for
+// requests that could never succeed.
+func (b *TokenBucket) Wait(ctx context.Context, at time.Time, tokens int) error {
+    if tokens <= 0 {
+        return ErrInvalidTokens
+    }
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    r := b.Reserve(at, tokens)
+    if !r.OK() {
+        return ErrExceedsCapacity
+    }
+    return r.sleep(ctx, at, r.Delay())
+}
+
+// AllowOrWait spends tokens at the given time if the bucket holds them, and
+// otherwise waits for them if they will have refilled within maxWait. at is
+// taken as the current time, as for Wait, and the wait is a single sleep
+// rather than polling. It returns false without spending anything if the
+// tokens would take longer than maxWait to arrive, if ctx is done before
+// they do, or if tokens is not positive.
+func (b *TokenBucket) AllowOrWait(ctx context.Context, at time.Time, tokens int, maxWait time.Duration) bool {
+    if ctx.Err() != nil {
+        return false
+    }
+    r := b.reserve(at, tokens, maxWait)
+    if !r.OK() {
+        return false
+    }
+    return r.sleep(ctx, at, r.Delay()) == nil
+}
+
+// sleep waits delay before the caller acts on r, cancelling r as of the
+// corresponding time after at if ctx is done first.
+func (r *Reservation) sleep(ctx context.Context, at time.Time, delay time.Duration) error {
+    if delay == 0 {
+        return nil
+    }
+    begin := time.Now()
+    timer := time.NewTimer(delay)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        r.Cancel(at.Add(time.Since(begin)))
+        return ctx.Err()
+    }
+}
+
+// Reserve claims tokens at the given time, whether or not the bucket holds
+// them yet, and returns a Reservation saying how long the caller must wait
+// before acting on it. Claimed tokens are spent straight away, possibly
+// leaving the balance negative, so later calls to Allow and Reserve see the
+// reservation and queue behind it. The reservation is not OK, and nothing is
+// claimed, if tokens is not positive or the request could never be met.
+func (b *TokenBucket) Reserve(at time.Time, tokens int) *Reservation {
+    return b.reserve(at, tokens, InfDuration)
+}
+
+// reserve implements Reserve, claiming nothing and returning a reservation
+// that is not OK if the caller would have to wait longer than maxWait.
+func (b *TokenBucket) reserve(at time.Time, tokens int, maxWait time.Duration) *Reservation {
+    if tokens <= 0 {
+        return &Reservation{}
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    need := float64(tokens)
+    b.refill(at)
+    if need > b.capacity || b.refillRate <= 0 && need > b.tokens {
+        return &Reservation{}
+    }
+    delay := b.delayFor(need)
+    if delay > maxWait {
+        return &Reservation{}
+    }
+    b.tokens -= need
+    r := &Reservation{bucket: b, ok: true, tokens: need, delay: delay, timeToAct: at.Add(delay)}
+    if r.timeToAct.After(b.lastEvent) {
+        b.lastEvent = r.timeToAct
+    }
+    return r
+}
+
+// InfDuration is the delay reported by a Reservation that is not OK.
+const InfDuration = time.Duration(math.MaxInt64)
+
+// Reservation holds tokens claimed from a TokenBucket by Reserve, in the
+// manner of golang.org/x/time/rate.
+type Reservation struct {
+    bucket    *TokenBucket
+    ok        bool
+    tokens    float64
+    delay     time.Duration
+    timeToAct time.Time
+    cancelled bool // guarded by bucket.mu
+}
+
+// OK reports whether the tokens were claimed. A caller holding a reservation
+// that is not OK must not act on it.
+func (r *Reservation) OK() bool { return r.ok }
+
+// Delay returns how long after the reservation was made the caller must wait
+// before acting on it, or InfDuration if it is not OK.
+func (r *Reservation) Delay() time.Duration {
+    if !r.ok {
+        return InfDuration
+    }
+    return r.delay
+}
+
+// DelayFrom returns how long after at the caller must wait before acting on
+// the reservation, zero if that time has already come, or InfDuration if it
+// is not OK.
+func (r *Reservation) DelayFrom(at time.Time) time.Duration {
+    if !r.ok {
+        return InfDuration
+    }
+    if d := r.timeToAct.Sub(at); d > 0 {
+        return d
+    }
+    return 0
+}
+
+// Cancel gives back, as of at, as many of the reserved tokens as possible
+// without disturbing reservations made after this one. It has no effect if
+// the reservation is not OK, has already been cancelled, or was due to be
+// acted on before at.
+func (r *Reservation) Cancel(at time.Time) {
+    if !r.ok {
+        return
+    }
+    b := r.bucket
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if r.cancelled || r.timeToAct.Before(at) {
+        return
+    }
+    r.cancelled = true
+    // Tokens claimed by later reservations have already been promised on the
+    // strength of this one's tokens being returned to the refill, so only
+    // the remainder can come back.
+    restore := r.tokens - b.lastEvent.Sub(r.timeToAct).Seconds()*b.refillRate
+    if restore <= 0 {
+        return
+    }
+    b.refill(at)
+    b.tokens += restore
+    if b.tokens > b.capacity {
+        b.tokens = b.capacity
+    }
+    if b.lastEvent.Equal(r.timeToAct) {
+        prev := r.timeToAct.Add(-time.Duration(r.tokens / b.refillRate * float64(time.Second)))
+        if prev.After(at) {
+            b.lastEvent = prev
+        } else {
+            b.lastEvent = at
+        }
+    }
+}
+
+// refill credits the tokens accrued between the last refill and at. b.mu
+// must be held.
+func (b *TokenBucket) refill(at time.Time) {
+    if !at.After(b.last) {
+        return
+    }
+    elapsed := at.Sub(b.last)
+    b.last = at
+    b.tokens += elapsed.Seconds() * b.refillRate
+    if b.tokens > b.capacity {
+        b.tokens = b.capacity
+    }
+}
+
+// take spends n tokens if the bucket holds them. b.mu must be held.
+func (b *TokenBucket) take(n float64) bool {
+    if n > b.tokens {
+        return false
+    }
+    b.tokens -= n
+    return true
+}
+
+// delayFor returns how long after the last refill the bucket will hold n
+// tokens, rounded up to the next nanosecond. b.mu must be held.
+func (b *TokenBucket) delayFor(n float64) time.Duration {
+    if n <= b.tokens {
+        return 0
+    }
+    nanos := (n - b.tokens) / b.refillRate * float64(time.Second)
+    d := time.Duration(nanos)
+    if float64(d) < nanos {
+        d++
+    }
+    return d
 }
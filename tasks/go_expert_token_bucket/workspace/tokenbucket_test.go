@@ -1,7 +1,11 @@
 package tokenbucket
 
 import (
+    "context"
+    "errors"
+    "math/rand"
     "sync"
+    "sync/atomic"
     "testing"
     "time"
 )
@@ -72,6 +76,11 @@ func TestConcurrentAccess(t *testing.T) {
     if failures == 0 {
         t.Fatalf("expected some calls to be throttled")
     }
+
+    want := Stats{GrantedTokens: successes * 3, GrantedRequests: successes, ThrottledRequests: failures}
+    if stats := bucket.Stats(); stats != want {
+        t.Fatalf("expected stats %+v to match observed outcomes %+v", stats, want)
+    }
 }
 
 var int64Mu sync.Mutex
@@ -81,3 +90,616 @@ func syncAddInt64(dst *int64, delta int64) {
     *dst += delta
     int64Mu.Unlock()
 }
+
+func TestWaitBlocksUntilRefill(t *testing.T) {
+    start := time.Now()
+    bucket := NewTokenBucket(2, 100, start)
+    if !bucket.Allow(start, 2) {
+        t.Fatalf("expected burst to succeed")
+    }
+
+    begin := time.Now()
+    if err := bucket.Wait(context.Background(), start, 2); err != nil {
+        t.Fatalf("wait returned error: %v", err)
+    }
+    // Two tokens at 100 per second take 20ms to accrue.
+    if elapsed := time.Since(begin); elapsed < 15*time.Millisecond {
+        t.Fatalf("expected wait to sleep for the refill, took %v", elapsed)
+    }
+    if bucket.Allow(start.Add(20*time.Millisecond), 1) {
+        t.Fatalf("wait should have spent the refilled tokens")
+    }
+}
+
+func TestWaitHonoursContextWithoutSpending(t *testing.T) {
+    start := time.Unix(0, 0)
+    bucket := NewTokenBucket(4, 1, start)
+    if !bucket.Allow(start, 3) {
+        t.Fatalf("expected burst to succeed")
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+    if err := bucket.Wait(ctx, start, 4); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("expected deadline exceeded, got %v", err)
+    }
+    if !bucket.Allow(start, 1) {
+        t.Fatalf("a cancelled wait must not spend tokens")
+    }
+
+    if err := bucket.Wait(context.Background(), start, 5); !errors.Is(err, ErrExceedsCapacity) {
+        t.Fatalf("expected ErrExceedsCapacity, got %v", err)
+    }
+    if err := bucket.Wait(context.Background(), start, 0); !errors.Is(err, ErrInvalidTokens) {
+        t.Fatalf("expected ErrInvalidTokens, got %v", err)
+    }
+}
+
+func TestAllowOrWaitGivesUpBeyondMaxWait(t *testing.T) {
+    start := time.Unix(0, 0)
+    bucket := NewTokenBucket(4, 10, start)
+    if !bucket.AllowOrWait(context.Background(), start, 3, 0) {
+        t.Fatalf("expected tokens on hand to be granted at once")
+    }
+
+    // Three more tokens take 200ms to refill.
+    begin := time.Now()
+    if bucket.AllowOrWait(context.Background(), start, 3, 100*time.Millisecond) {
+        t.Fatalf("expected admission to fail when the refill outlasts maxWait")
+    }
+    if elapsed := time.Since(begin); elapsed > 50*time.Millisecond {
+        t.Fatalf("expected a hopeless request to fail without waiting, took %v", elapsed)
+    }
+    if got := bucket.Tokens(start); got != 1 {
+        t.Fatalf("a refused request must not spend tokens, balance %v", got)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+    if bucket.AllowOrWait(ctx, start, 3, time.Second) {
+        t.Fatalf("expected admission to fail once ctx is done")
+    }
+    // Cancelling credits the refill up to when ctx was done.
+    if got := bucket.Tokens(start); got < 1 {
+        t.Fatalf("a cancelled wait must not spend tokens, balance %v", got)
+    }
+
+    // Two tokens take at most 100ms more.
+    begin = time.Now()
+    if !bucket.AllowOrWait(context.Background(), start, 2, 150*time.Millisecond) {
+        t.Fatalf("expected admission once the tokens refill within maxWait")
+    }
+    if elapsed := time.Since(begin); elapsed < 50*time.Millisecond {
+        t.Fatalf("expected AllowOrWait to sleep for the refill, took %v", elapsed)
+    }
+}
+
+func TestReserveReportsDelayAndQueuesCallers(t *testing.T) {
+    start := time.Unix(0, 0)
+    bucket := NewTokenBucket(4, 2, start)
+
+    now := bucket.Reserve(start, 3)
+    if !now.OK() || now.Delay() != 0 {
+        t.Fatalf("expected an immediate reservation, got ok=%v delay=%v", now.OK(), now.Delay())
+    }
+
+    // One token is left, so two more take a further half second at 2/s.
+    later := bucket.Reserve(start, 3)
+    if !later.OK() || later.Delay() != time.Second {
+        t.Fatalf("expected a 1s delay, got ok=%v delay=%v", later.OK(), later.Delay())
+    }
+    if d := later.DelayFrom(start.Add(400 * time.Millisecond)); d != 600*time.Millisecond {
+        t.Fatalf("expected 600ms left, got %v", d)
+    }
+    if bucket.Allow(start.Add(500*time.Millisecond), 1) {
+        t.Fatalf("reserved tokens must not be available to Allow")
+    }
+
+    if r := bucket.Reserve(start, 5); r.OK() || r.Delay() != InfDuration {
+        t.Fatalf("a request larger than capacity can never be reserved")
+    }
+}
+
+func TestReservationCancelReturnsTokens(t *testing.T) {
+    start := time.Unix(0, 0)
+    bucket := NewTokenBucket(4, 1, start)
+    if !bucket.Allow(start, 4) {
+        t.Fatalf("expected burst to succeed")
+    }
+
+    r := bucket.Reserve(start, 2)
+    if r.Delay() != 2*time.Second {
+        t.Fatalf("expected a 2s delay, got %v", r.Delay())
+    }
+    r.Cancel(start)
+    r.Cancel(start)
+    if !bucket.Allow(start.Add(time.Second), 1) {
+        t.Fatalf("cancelled tokens should be back in the refill")
+    }
+
+    // A reservation behind another only gets back what the later one has
+    // not already been promised.
+    first := bucket.Reserve(start.Add(time.Second), 2)
+    second := bucket.Reserve(start.Add(time.Second), 1)
+    if second.Delay() != 3*time.Second {
+        t.Fatalf("expected the second reservation to wait 3s, got %v", second.Delay())
+    }
+    first.Cancel(start.Add(time.Second))
+    if bucket.Allow(start.Add(3*time.Second), 1) {
+        t.Fatalf("cancelling must not hand out tokens promised to a later reservation")
+    }
+    if !bucket.Allow(start.Add(4*time.Second), 1) {
+        t.Fatalf("expected the unpromised token to be returned")
+    }
+}
+
+func TestTokensReportsFractionalBalance(t *testing.T) {
+    start := time.Unix(0, 0)
+    bucket := NewTokenBucket(10, 5, start)
+    if got := bucket.Tokens(start); got != 10 {
+        t.Fatalf("expected a full bucket, got %v", got)
+    }
+    if !bucket.Allow(start, 10) {
+        t.Fatalf("expected burst to succeed")
+    }
+
+    afterHalfSecond := start.Add(500 * time.Millisecond)
+    if got := bucket.Tokens(afterHalfSecond); got != 2.5 {
+        t.Fatalf("expected 2.5 tokens after half a second, got %v", got)
+    }
+    if got := bucket.Tokens(afterHalfSecond); got != 2.5 {
+        t.Fatalf("reading the balance must not spend it, got %v", got)
+    }
+    if got := bucket.Tokens(start.Add(time.Minute)); got != 10 {
+        t.Fatalf("expected the balance to be clamped to capacity, got %v", got)
+    }
+}
+
+func TestTimeToNextToken(t *testing.T) {
+    start := time.Unix(0, 0)
+    bucket := NewTokenBucket(10, 5, start)
+    if got := bucket.TimeToNextToken(start); got != 0 {
+        t.Fatalf("expected no wait with a full bucket, got %v", got)
+    }
+    bucket.Allow(start, 10)
+
+    // 2.5 tokens after half a second; spending 2 leaves half a token, and
+    // the other half takes 100ms at 5 per second.
+    afterHalfSecond := start.Add(500 * time.Millisecond)
+    if got := bucket.TimeToNextToken(afterHalfSecond); got != 0 {
+        t.Fatalf("expected no wait with 2.5 tokens, got %v", got)
+    }
+    bucket.Allow(afterHalfSecond, 2)
+    if got := bucket.TimeToNextToken(afterHalfSecond); got != 100*time.Millisecond {
+        t.Fatalf("expected 100ms until the next token, got %v", got)
+    }
+    // Rounding up to whole nanoseconds may add one.
+    if got := bucket.TimeToNextToken(afterHalfSecond.Add(40 * time.Millisecond)); got < 60*time.Millisecond || got > 60*time.Millisecond+time.Nanosecond {
+        t.Fatalf("expected 60ms until the next token, got %v", got)
+    }
+    if got := bucket.Tokens(afterHalfSecond); got != 0.5 {
+        t.Fatalf("TimeToNextToken must not change the balance, got %v", got)
+    }
+
+    bucket.SetRate(0, afterHalfSecond)
+    if got := bucket.TimeToNextToken(afterHalfSecond); got != InfDuration {
+        t.Fatalf("expected InfDuration without refill, got %v", got)
+    }
+}
+
+func TestSetRateKeepsAccruedTokens(t *testing.T) {
+    start := time.Unix(0, 0)
+    bucket := NewTokenBucket(10, 1, start)
+    if !bucket.Allow(start, 10) {
+        t.Fatalf("expected burst to succeed")
+    }
+
+    // Two tokens accrue at the old rate before it rises to 4/s.
+    bucket.SetRate(4, start.Add(2*time.Second))
+    if bucket.Allow(start.Add(2*time.Second), 3) {
+        t.Fatalf("only two tokens should have accrued at the old rate")
+    }
+    if !bucket.Allow(start.Add(2500*time.Millisecond), 4) {
+        t.Fatalf("expected the new rate to add two tokens in half a second")
+    }
+    if bucket.Allow(start.Add(2500*time.Millisecond), 1) {
+        t.Fatalf("bucket should be empty")
+    }
+}
+
+func TestSetCapacityClampsBalance(t *testing.T) {
+    start := time.Unix(0, 0)
+    bucket := NewTokenBucket(10, 1, start)
+    bucket.SetCapacity(4, start)
+    if bucket.Allow(start, 5) {
+        t.Fatalf("balance should be clamped to the new capacity")
+    }
+    if !bucket.Allow(start, 4) {
+        t.Fatalf("expected the clamped balance to be spendable")
+    }
+
+    bucket.SetCapacity(20, start)
+    if got := bucket.Tokens(start.Add(time.Minute)); got != 20 {
+        t.Fatalf("expected refill up to the raised capacity, got %v", got)
+    }
+}
+
+func TestLimiterKeysHaveIndependentBudgets(t *testing.T) {
+    start := time.Unix(0, 0)
+    limiter := NewLimiter(3, 1, time.Minute)
+
+    if !limiter.AllowKey("alice", start, 3) {
+        t.Fatalf("expected alice's burst to succeed")
+    }
+    if limiter.AllowKey("alice", start, 1) {
+        t.Fatalf("alice should be out of tokens")
+    }
+    if !limiter.AllowKey("bob", start, 3) {
+        t.Fatalf("bob's budget should not be affected by alice")
+    }
+}
+
+func TestLimiterForgetsIdleKeys(t *testing.T) {
+    start := time.Unix(0, 0)
+    limiter := NewLimiter(3, 1, time.Minute)
+    limiter.AllowKey("alice", start, 1)
+    limiter.AllowKey("bob", start.Add(30*time.Second), 1)
+
+    if n := limiter.Prune(start.Add(time.Minute)); n != 1 || limiter.Len() != 1 {
+        t.Fatalf("expected only alice to be pruned, removed %d leaving %d", n, limiter.Len())
+    }
+
+    // AllowKey prunes on its own once an idle TTL has passed.
+    limiter.AllowKey("carol", start.Add(3*time.Minute), 1)
+    if limiter.Len() != 1 {
+        t.Fatalf("expected bob to be pruned by AllowKey, %d buckets left", limiter.Len())
+    }
+}
+
+func TestLimiterConcurrentKeys(t *testing.T) {
+    start := time.Unix(0, 0)
+    limiter := NewLimiter(5, 1, time.Second)
+    var wg sync.WaitGroup
+    var granted int64
+    for i := 0; i < 50; i++ {
+        wg.Add(1)
+        go func(idx int) {
+            defer wg.Done()
+            if limiter.AllowKey(string(rune('a'+idx%5)), start, 1) {
+                syncAddInt64(&granted, 1)
+            }
+        }(i)
+    }
+    wg.Wait()
+    if granted != 25 {
+        t.Fatalf("expected each of 5 keys to grant 5 tokens, got %d", granted)
+    }
+}
+
+type fakeClock struct {
+    mu  sync.Mutex
+    now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = c.now.Add(d)
+}
+
+func TestAllowNowReadsInjectedClock(t *testing.T) {
+    clock := &fakeClock{now: time.Unix(100, 0)}
+    bucket := NewTokenBucketClock(2, 1, clock)
+
+    if !bucket.AllowNow(2) {
+        t.Fatalf("expected burst to succeed")
+    }
+    if bucket.AllowNow(1) {
+        t.Fatalf("bucket should be empty")
+    }
+    clock.Advance(time.Second)
+    if !bucket.AllowNow(1) {
+        t.Fatalf("expected a token after the clock advanced")
+    }
+    clock.Advance(time.Second)
+    if err := bucket.WaitNow(context.Background(), 1); err != nil {
+        t.Fatalf("wait returned error: %v", err)
+    }
+}
+
+func TestLeakyBucketAdmitsUpToQueueSize(t *testing.T) {
+    start := time.Unix(0, 0)
+    bucket := NewLeakyBucket(4, 2, start)
+
+    if !bucket.Allow(start, 3) || !bucket.Allow(start, 1) {
+        t.Fatalf("expected the queue to take 4 units")
+    }
+    if bucket.Allow(start, 1) {
+        t.Fatalf("queue should be full")
+    }
+    if got := bucket.Depth(start.Add(250 * time.Millisecond)); got != 3.5 {
+        t.Fatalf("expected depth 3.5 after draining a quarter second, got %v", got)
+    }
+    if bucket.Allow(start.Add(250*time.Millisecond), 1) {
+        t.Fatalf("half a unit of room is not enough for a whole one")
+    }
+    if !bucket.Allow(start.Add(500*time.Millisecond), 1) {
+        t.Fatalf("expected room for one unit after half a second")
+    }
+    if got := bucket.Depth(start.Add(time.Minute)); got != 0 {
+        t.Fatalf("expected the queue to drain fully, got %v", got)
+    }
+    if bucket.Allow(start.Add(time.Minute), 5) || bucket.Allow(start, 0) {
+        t.Fatalf("oversized and non-positive requests should be rejected")
+    }
+}
+
+func TestStateRoundTrip(t *testing.T) {
+    start := time.Unix(0, 0)
+    original := NewTokenBucket(10, 2, start)
+    if !original.Allow(start.Add(time.Second), 9) {
+        t.Fatalf("expected burst to succeed")
+    }
+    data, err := original.MarshalState()
+    if err != nil {
+        t.Fatalf("marshal failed: %v", err)
+    }
+
+    // The restarted process builds a fresh bucket much later.
+    restored := NewTokenBucket(10, 2, start.Add(time.Hour))
+    if err := restored.RestoreState(data); err != nil {
+        t.Fatalf("restore failed: %v", err)
+    }
+
+    at := start.Add(2 * time.Second)
+    if got, want := restored.Tokens(at), original.Tokens(at); got != want {
+        t.Fatalf("expected restored balance %v, got %v", want, got)
+    }
+    if !restored.Allow(at, 3) || restored.Allow(at, 1) {
+        t.Fatalf("expected exactly three tokens two seconds in")
+    }
+
+    if err := restored.RestoreState([]byte("{")); err == nil {
+        t.Fatalf("expected an error for malformed state")
+    }
+}
+
+func TestAllowPartialGrantsWhatIsAvailable(t *testing.T) {
+    start := time.Unix(0, 0)
+    bucket := NewTokenBucket(10, 1, start)
+    if !bucket.Allow(start, 7) {
+        t.Fatalf("expected burst to succeed")
+    }
+
+    if got := bucket.AllowPartial(start, 5); got != 3 {
+        t.Fatalf("expected 3 of 5 tokens to be granted, got %d", got)
+    }
+    if got := bucket.Tokens(start); got != 0 {
+        t.Fatalf("expected the bucket to be emptied, got %v", got)
+    }
+    if got := bucket.AllowPartial(start.Add(1500*time.Millisecond), 5); got != 1 {
+        t.Fatalf("expected only the whole accrued token, got %d", got)
+    }
+    if got := bucket.AllowPartial(start.Add(time.Minute), 4); got != 4 {
+        t.Fatalf("expected the full request when tokens suffice, got %d", got)
+    }
+    if got := bucket.AllowPartial(start.Add(time.Minute), 0); got != 0 {
+        t.Fatalf("non-positive requests should be granted nothing, got %d", got)
+    }
+}
+
+func TestMultiLimiterRollsBackOnDenial(t *testing.T) {
+    start := time.Unix(0, 0)
+    perSecond := NewTokenBucket(5, 5, start)
+    perMinute := NewTokenBucket(8, 8.0/60, start)
+    limiter := NewMultiLimiter(perSecond, perMinute)
+
+    if !limiter.Allow(start, 4) {
+        t.Fatalf("expected both buckets to grant the first request")
+    }
+
+    // The per-second bucket has refilled but the per-minute one has not.
+    at := start.Add(time.Second)
+    ok, denied := limiter.AllowWithReason(at, 5)
+    if ok || denied != 1 {
+        t.Fatalf("expected the per-minute bucket to deny, got ok=%v denied=%d", ok, denied)
+    }
+    if got := perSecond.Tokens(at); got != 5 {
+        t.Fatalf("expected the per-second grant to be rolled back, balance %v", got)
+    }
+
+    if ok, denied := limiter.AllowWithReason(at, 4); !ok || denied != -1 {
+        t.Fatalf("expected a request within both limits to pass, got ok=%v denied=%d", ok, denied)
+    }
+    if ok, denied := limiter.AllowWithReason(at, 2); ok || denied != 0 {
+        t.Fatalf("expected the per-second bucket to deny, got ok=%v denied=%d", ok, denied)
+    }
+}
+
+func TestChildBucketThrottledByExhaustedParent(t *testing.T) {
+    start := time.Unix(0, 0)
+    parent := NewTokenBucket(6, 1, start)
+    tenantA := NewChildBucket(parent, 4, 1, start)
+    tenantB := NewChildBucket(parent, 4, 1, start)
+
+    if !tenantA.Allow(start, 4) {
+        t.Fatalf("expected tenant A's first request to pass")
+    }
+    if ok, denied := tenantA.AllowWithReason(start, 1); ok || denied != LevelChild {
+        t.Fatalf("expected tenant A's own quota to deny, got ok=%v denied=%v", ok, denied)
+    }
+    if ok, denied := tenantB.AllowWithReason(start, 3); ok || denied != LevelParent {
+        t.Fatalf("expected the shared parent to deny, got ok=%v denied=%v", ok, denied)
+    }
+    if got := tenantB.Tokens(start); got != 4 {
+        t.Fatalf("expected tenant B's tokens to be left alone, balance %v", got)
+    }
+    if got := parent.Tokens(start); got != 2 {
+        t.Fatalf("expected the parent to be charged only for the grant, balance %v", got)
+    }
+    if ok, denied := tenantB.AllowWithReason(start, 2); !ok || denied != LevelNone {
+        t.Fatalf("expected a request within both limits to pass, got ok=%v denied=%v", ok, denied)
+    }
+    if got := tenantB.Tokens(start); got != 2 {
+        t.Fatalf("expected tenant B to be charged for the grant, balance %v", got)
+    }
+}
+
+func TestMultiLimiterIsAtomicUnderContention(t *testing.T) {
+    start := time.Unix(0, 0)
+    wide := NewTokenBucket(100, 0, start)
+    narrow := NewTokenBucket(50, 0, start)
+    // Two limiters listing the shared buckets in opposite orders must not
+    // deadlock.
+    limiters := []*MultiLimiter{NewMultiLimiter(wide, narrow), NewMultiLimiter(narrow, wide)}
+
+    var wg sync.WaitGroup
+    var granted atomic.Int64
+    for i := 0; i < 200; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            if limiters[i%2].Allow(start, 1) {
+                granted.Add(1)
+            }
+        }(i)
+    }
+    wg.Wait()
+
+    if n := granted.Load(); n != 50 {
+        t.Fatalf("expected exactly 50 grants, got %d", n)
+    }
+    if got := wide.Tokens(start); got != 50 {
+        t.Fatalf("expected the wide bucket to be charged only for grants, balance %v", got)
+    }
+    if stats := wide.Stats(); stats.GrantedRequests != 50 {
+        t.Fatalf("expected the wide bucket to count only real grants, got %+v", stats)
+    }
+    if ok, denied := NewMultiLimiter(wide, wide).AllowWithReason(start, 30); ok || denied != 1 {
+        t.Fatalf("expected a bucket listed twice to be charged twice, got ok=%v denied=%d", ok, denied)
+    }
+}
+
+func TestResetRestoresFullBurst(t *testing.T) {
+    start := time.Unix(0, 0)
+    bucket := NewTokenBucket(6, 1, start)
+    if !bucket.Allow(start, 6) {
+        t.Fatalf("expected burst to succeed")
+    }
+    bucket.Reserve(start, 4)
+    if bucket.Allow(start, 1) {
+        t.Fatalf("bucket should be empty")
+    }
+
+    at := start.Add(time.Second)
+    bucket.Reset(at)
+    if !bucket.Allow(at, 6) {
+        t.Fatalf("expected a full burst after reset")
+    }
+    if bucket.Allow(at, 1) {
+        t.Fatalf("reset should refill to capacity and no further")
+    }
+}
+
+func TestDrainAllEmptiesUntilRefill(t *testing.T) {
+    start := time.Unix(0, 0)
+    bucket := NewTokenBucket(10, 4, start)
+    at := start.Add(time.Second)
+    bucket.DrainAll(at)
+    if bucket.Allow(at, 1) {
+        t.Fatalf("expected drained bucket to refuse")
+    }
+    if got := bucket.Tokens(at.Add(time.Second)); got != 4 {
+        t.Fatalf("expected exactly one second of refill, 4 tokens, got %v", got)
+    }
+
+    bucket.Reserve(at.Add(time.Second), 10)
+    bucket.DrainAll(at.Add(time.Second))
+    if got := bucket.Tokens(at.Add(time.Second)); got != -6 {
+        t.Fatalf("expected reservation debt to be kept, got %v", got)
+    }
+}
+
+// fixedSource always draws the same value, to pin down WaitJitter's jitter.
+type fixedSource int64
+
+func (s fixedSource) Int63() int64 { return int64(s) }
+func (fixedSource) Seed(int64)     {}
+
+func TestWaitJitterCancelledDuringJitterSpendsNothing(t *testing.T) {
+    start := time.Now()
+    bucket := NewTokenBucket(5, 10, start)
+    // Int63n(1s+1) returns the drawn value as is: a full second of jitter.
+    bucket.SetJitterSource(fixedSource(time.Second))
+    if !bucket.Allow(start, 5) {
+        t.Fatalf("expected burst to succeed")
+    }
+
+    // The token is due after 100ms; ctx ends during the jitter after it.
+    ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+    defer cancel()
+    if err := bucket.WaitJitter(ctx, start, 1, time.Second); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("expected deadline exceeded, got %v", err)
+    }
+    // About 1.5 tokens have refilled by now; keeping the reserved one would
+    // leave about 0.5.
+    if got := bucket.Tokens(start); got < 1.4 {
+        t.Fatalf("a cancelled wait must not spend tokens, balance %v", got)
+    }
+}
+
+func TestJitteredDelayStaysInBounds(t *testing.T) {
+    bucket := NewTokenBucket(1, 1, time.Unix(0, 0))
+    bucket.SetJitterSource(rand.NewSource(1))
+    base, maxJitter := 100*time.Millisecond, 50*time.Millisecond
+    var draws []time.Duration
+    spread := false
+    for i := 0; i < 1000; i++ {
+        d := bucket.jittered(base, maxJitter)
+        if d < base || d > base+maxJitter {
+            t.Fatalf("jittered delay %v outside [%v, %v]", d, base, base+maxJitter)
+        }
+        if i > 0 && d != draws[0] {
+            spread = true
+        }
+        draws = append(draws, d)
+    }
+    if !spread {
+        t.Fatalf("expected jitter to vary the delay")
+    }
+
+    // The same seed replays the same delays.
+    bucket.SetJitterSource(rand.NewSource(1))
+    for i := range draws {
+        if d := bucket.jittered(base, maxJitter); d != draws[i] {
+            t.Fatalf("draw %d: expected %v with the same seed, got %v", i, draws[i], d)
+        }
+    }
+    if d := bucket.jittered(0, maxJitter); d != 0 {
+        t.Fatalf("expected no jitter when no wait is needed, got %v", d)
+    }
+}
+
+func TestWaitJitterWaitsAtLeastTheBaseDelay(t *testing.T) {
+    start := time.Now()
+    bucket := NewTokenBucket(1, 100, start)
+    bucket.SetJitterSource(rand.NewSource(2))
+    if !bucket.Allow(start, 1) {
+        t.Fatalf("expected burst to succeed")
+    }
+    if err := bucket.WaitJitter(context.Background(), start, 1, 10*time.Millisecond); err != nil {
+        t.Fatalf("wait returned error: %v", err)
+    }
+    if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+        t.Fatalf("expected to wait at least the 10ms refill, waited %v", elapsed)
+    }
+    if err := bucket.WaitJitter(context.Background(), start, 2, time.Millisecond); !errors.Is(err, ErrExceedsCapacity) {
+        t.Fatalf("expected ErrExceedsCapacity, got %v", err)
+    }
+}
@@ -0,0 +1,50 @@
+package textutil
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// WordCountAll counts the words of every input, normalized as by WordCount,
+// and returns the combined counts. Inputs are counted in parallel by up to
+// GOMAXPROCS goroutines; since counts are summed, the result does not depend
+// on how the work was scheduled.
+func WordCountAll(inputs []string) map[string]int {
+	workers := min(runtime.GOMAXPROCS(0), len(inputs))
+	if workers <= 1 {
+		counts := make(map[string]int)
+		for _, input := range inputs {
+			addWords(counts, input, DefaultOptions())
+		}
+		return counts
+	}
+
+	partial := make([]map[string]int, workers)
+	var next atomic.Int64 // index of the next input to count
+	var wg sync.WaitGroup
+	for w := range partial {
+		counts := make(map[string]int)
+		partial[w] = counts
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(inputs) {
+					return
+				}
+				addWords(counts, inputs[i], DefaultOptions())
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := partial[0]
+	for _, counts := range partial[1:] {
+		for word, n := range counts {
+			total[word] += n
+		}
+	}
+	return total
+}
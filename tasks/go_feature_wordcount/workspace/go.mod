@@ -1,3 +1,5 @@
 module go_feature_wordcount
 
 go 1.21
+
+require golang.org/x/text v0.14.0
@@ -0,0 +1,24 @@
+package textutil
+
+import "strings"
+
+// NGramCount counts the runs of n consecutive words in input, normalized as
+// by WordCount, keyed by the words joined with single spaces. Words are
+// taken as one stream, so n-grams run across punctuation, sentence ends and
+// line breaks: "end. Start" yields the bigram "end start". Inputs with fewer
+// than n words, and n less than 1, give an empty map.
+func NGramCount(input string, n int) map[string]int {
+	counts := make(map[string]int)
+	if n < 1 {
+		return counts
+	}
+	opts := DefaultOptions()
+	var words []string
+	forEachWord(input, opts.joins, func(word string) {
+		words = append(words, opts.normalize(word))
+	})
+	for i := 0; i+n <= len(words); i++ {
+		counts[strings.Join(words[i:i+n], " ")]++
+	}
+	return counts
+}
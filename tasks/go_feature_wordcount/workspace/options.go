@@ -0,0 +1,95 @@
+package textutil
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Options controls how WordCountWith splits and normalizes words. The zero
+// value is case-insensitive, splits on hyphens and strips apostrophes; use
+// DefaultOptions for the rules WordCount follows.
+type Options struct {
+	// CaseSensitive counts words as written instead of lowercasing them.
+	CaseSensitive bool
+	// KeepHyphens keeps hyphenated words such as "state-of-the-art" whole
+	// rather than counting each part separately.
+	KeepHyphens bool
+	// KeepApostrophes keeps apostrophes within words, so "can't" is counted
+	// as written rather than as "cant".
+	KeepApostrophes bool
+	// IgnoreNumbers drops words made up only of digits, such as "2023" or
+	// each part of "3.14", along with digit runs joined by hyphens such as
+	// "2023-2024". Words with any letter in them, such as "v2", still count.
+	IgnoreNumbers bool
+	// MinLength drops words shorter than this many runes, after
+	// normalization, keeping those exactly this long. Runes rather than
+	// bytes are counted, so "café" is four long. Zero keeps every word.
+	MinLength int
+}
+
+// DefaultOptions returns the Options WordCount uses: case-insensitive, with
+// hyphens and apostrophes kept and no minimum length.
+func DefaultOptions() Options {
+	return Options{KeepHyphens: true, KeepApostrophes: true}
+}
+
+// WordCountWith is WordCount with the tokenization and normalization rules
+// given by opts.
+func WordCountWith(input string, opts Options) map[string]int {
+	counts := make(map[string]int)
+	addWords(counts, input, opts)
+	return counts
+}
+
+// WordCountFunc splits input into words like WordCount but counts each
+// under normalize(word) in place of WordCount's normalization. Words are
+// passed exactly as they appear in input, and those normalize maps to "" are
+// not counted.
+func WordCountFunc(input string, normalize func(string) string) map[string]int {
+	counts := make(map[string]int)
+	forEachWord(input, DefaultOptions().joins, func(word string) {
+		if word = normalize(word); word != "" {
+			counts[word]++
+		}
+	})
+	return counts
+}
+
+// addWords counts the words of s into counts, normalized according to opts.
+func addWords(counts map[string]int, s string, opts Options) {
+	forEachWord(s, opts.joins, func(word string) {
+		if word = opts.normalize(word); word != "" {
+			counts[word]++
+		}
+	})
+}
+
+// joins reports whether r joins two parts of a word. Apostrophes always do,
+// so that normalize can strip them without splitting the word.
+func (o Options) joins(r rune) bool {
+	return r == '\'' || r == '-' && o.KeepHyphens
+}
+
+// normalize returns word as it should be counted, or "" if it should not be.
+// Words are first converted to NFC so that precomposed and decomposed
+// spellings of the same text, such as "café" with "é" or with "e" and a
+// combining accent, are counted together.
+func (o Options) normalize(word string) string {
+	word = norm.NFC.String(word)
+	if !o.KeepApostrophes {
+		word = strings.ReplaceAll(word, "'", "")
+	}
+	if !o.CaseSensitive {
+		word = strings.ToLower(word)
+	}
+	if utf8.RuneCountInString(word) < o.MinLength {
+		return ""
+	}
+	if o.IgnoreNumbers && strings.IndexFunc(word, unicode.IsLetter) < 0 {
+		return ""
+	}
+	return word
+}
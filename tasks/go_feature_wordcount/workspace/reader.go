@@ -0,0 +1,53 @@
+package textutil
+
+import (
+	"bufio"
+	"io"
+	"unicode/utf8"
+)
+
+// WordCountReader is WordCount over the text read from r, which it reads
+// incrementally rather than all at once. Words split across reads are
+// counted whole. It returns the counts so far along with any read error. A
+// single run of letters, digits, hyphens and apostrophes longer than
+// bufio.MaxScanTokenSize is reported as bufio.ErrTooLong.
+func WordCountReader(r io.Reader) (map[string]int, error) {
+	counts := make(map[string]int)
+	sc := bufio.NewScanner(r)
+	sc.Split(scanChunks)
+	for sc.Scan() {
+		addWords(counts, sc.Text(), DefaultOptions())
+	}
+	return counts, sc.Err()
+}
+
+// scanChunks is a bufio.SplitFunc returning the runs of word runes and
+// joiners between delimiters. Whether a joiner belongs to a word depends only
+// on its neighbours, so forEachWord finds the same words in each chunk as it
+// would in the whole text.
+func scanChunks(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	start, i := -1, 0
+	for i < len(data) {
+		if !atEOF && !utf8.FullRune(data[i:]) {
+			break
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		inChunk := isWordRune(r) || isJoiner(r)
+		switch {
+		case inChunk && start < 0:
+			start = i
+		case !inChunk && start >= 0:
+			return i + size, data[start:i], nil
+		}
+		i += size
+	}
+	switch {
+	case start < 0:
+		// Skip the delimiters scanned so far.
+		return i, nil, nil
+	case atEOF:
+		return len(data), data[start:], nil
+	}
+	// Ask for more data to finish the chunk.
+	return start, nil, nil
+}
@@ -0,0 +1,39 @@
+package textutil
+
+import "strings"
+
+// defaultStopWords is a short list of very common English words.
+var defaultStopWords = []string{
+	"a", "about", "all", "an", "and", "are", "as", "at", "be", "but", "by",
+	"can", "do", "for", "from", "had", "has", "have", "he", "her", "his",
+	"i", "if", "in", "into", "is", "it", "it's", "its", "me", "my", "no",
+	"not", "of", "on", "or", "our", "she", "so", "that", "the", "their",
+	"them", "then", "there", "they", "this", "to", "was", "we", "were",
+	"what", "when", "which", "who", "will", "with", "you", "your",
+}
+
+// DefaultStopWords returns a new set of common English stop words for use
+// with WordCountFiltered. Callers may add to or remove from it freely.
+func DefaultStopWords() map[string]struct{} {
+	set := make(map[string]struct{}, len(defaultStopWords))
+	for _, w := range defaultStopWords {
+		set[w] = struct{}{}
+	}
+	return set
+}
+
+// WordCountFiltered is WordCount without the words in stopWords. Stop words
+// match case-insensitively, like counted words; stopWords is not modified.
+func WordCountFiltered(input string, stopWords map[string]struct{}) map[string]int {
+	stop := make(map[string]struct{}, len(stopWords))
+	for w := range stopWords {
+		stop[strings.ToLower(w)] = struct{}{}
+	}
+	counts := WordCount(input)
+	for w := range counts {
+		if _, ok := stop[w]; ok {
+			delete(counts, w)
+		}
+	}
+	return counts
+}
@@ -1,15 +1,159 @@
 package textutil
 
-// WordCount should normalize words and return a frequency map.
-//
-// Requirements:
-//   * Case-insensitive comparisons.
-//   * Hyphenated words should remain intact ("state-of-the-art").
-//   * Apostrophes within words ("can't") should be kept.
-//   * All other punctuation should be treated as delimiters.
-//   * Multiple whitespace characters should be treated as single separators.
+import (
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WordFreq is a word and the number of times it occurs.
+type WordFreq struct {
+	Word  string
+	Count int
+}
+
+// WordCount normalizes words and returns a frequency map. It is
+// WordCountWith using DefaultOptions.
 //
-// The current placeholder implementation is intentionally incorrect.
+// Rules:
+//   - Case-insensitive comparisons; keys are lowercase.
+//   - Hyphenated words remain intact ("state-of-the-art").
+//   - Apostrophes within words ("can't") are kept.
+//   - All other punctuation is treated as a delimiter, as are hyphens and
+//     apostrophes that do not join two letters or digits.
+//   - Runs of whitespace are treated as single separators.
 func WordCount(input string) map[string]int {
-	return map[string]int{"TODO": len(input)}
+	return WordCountWith(input, DefaultOptions())
+}
+
+// WordStats returns WordCount(input) together with the total number of words
+// counted and the number of distinct ones, len(freq).
+func WordStats(input string) (freq map[string]int, total, distinct int) {
+	freq = WordCount(input)
+	for _, n := range freq {
+		total += n
+	}
+	return freq, total, len(freq)
+}
+
+// CountAll returns the number of words, lines and characters in input in a
+// single pass. words is the total that WordStats reports, following
+// WordCount's tokenization. lines counts newline-terminated lines plus a
+// final unterminated one, so a trailing newline does not start another line:
+// "a\nb" and "a\nb\n" both have 2 lines, and "" has none. chars counts
+// runes, newlines included, with each byte of invalid UTF-8 counting as one.
+func CountAll(input string) (words, lines, chars int) {
+	joins := DefaultOptions().joins
+	inWord := false
+	for i, r := range input {
+		chars++
+		switch {
+		case isWordRune(r):
+			if !inWord {
+				words++
+				inWord = true
+			}
+		case inWord && joins(r):
+			// As in forEachWord, a joiner only continues a word that
+			// resumes straight after it.
+			next, _ := utf8.DecodeRuneInString(input[i+1:])
+			inWord = isWordRune(next)
+		default:
+			inWord = false
+		}
+		if r == '\n' {
+			lines++
+		}
+	}
+	if input != "" && input[len(input)-1] != '\n' {
+		lines++
+	}
+	return words, lines, chars
+}
+
+// WordPercentages returns each word's share of all the words in input,
+// normalized as by WordCount, as a fraction between 0 and 1. Empty input
+// gives an empty map.
+func WordPercentages(input string) map[string]float64 {
+	freq, total, _ := WordStats(input)
+	shares := make(map[string]float64, len(freq))
+	for word, n := range freq {
+		shares[word] = float64(n) / float64(total)
+	}
+	return shares
+}
+
+// TopWords returns the n most frequent words in input, normalized as by
+// WordCount, ordered by descending count and then alphabetically so that
+// ties are stable. It returns every word if there are fewer than n, and nil
+// if n is not positive.
+func TopWords(input string, n int) []WordFreq {
+	if n <= 0 {
+		return nil
+	}
+	freqs := SortedWordCount(input)
+	if len(freqs) > n {
+		freqs = freqs[:n]
+	}
+	return freqs
+}
+
+// SortedWordCount returns every word in input with its count, normalized as
+// by WordCount, ordered by descending count and then ascending word, so
+// that the order is the same on every call.
+func SortedWordCount(input string) []WordFreq {
+	return sortedFreqs(WordCount(input))
+}
+
+// sortedFreqs returns counts as a slice ordered by descending count and then
+// ascending word.
+func sortedFreqs(counts map[string]int) []WordFreq {
+	freqs := make([]WordFreq, 0, len(counts))
+	for word, count := range counts {
+		freqs = append(freqs, WordFreq{Word: word, Count: count})
+	}
+	sort.Slice(freqs, func(i, j int) bool {
+		if freqs[i].Count != freqs[j].Count {
+			return freqs[i].Count > freqs[j].Count
+		}
+		return freqs[i].Word < freqs[j].Word
+	})
+	return freqs
+}
+
+// forEachWord calls fn with each word of s in order, without normalizing
+// it. A word is a run of letters, digits and combining marks, possibly
+// joined by single runes for which joins reports true.
+func forEachWord(s string, joins func(rune) bool, fn func(word string)) {
+	start, end := -1, -1 // bounds of the current word, or -1 between words
+	for i, r := range s {
+		switch {
+		case isWordRune(r):
+			if start < 0 {
+				start = i
+			}
+			end = i + utf8.RuneLen(r)
+			continue
+		case joins(r) && start >= 0 && end == i:
+			if next, _ := utf8.DecodeRuneInString(s[i+1:]); isWordRune(next) {
+				continue
+			}
+		}
+		if start >= 0 {
+			fn(s[start:end])
+			start = -1
+		}
+	}
+	if start >= 0 {
+		fn(s[start:end])
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// isJoiner reports whether r may join two parts of a word under any Options.
+func isJoiner(r rune) bool {
+	return r == '-' || r == '\''
 }
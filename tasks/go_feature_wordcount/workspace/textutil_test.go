@@ -1,6 +1,12 @@
 package textutil
 
-import "testing"
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
 
 func TestEmptyInput(t *testing.T) {
 	result := WordCount("")
@@ -45,6 +51,230 @@ func TestUnicodeLetters(t *testing.T) {
 	assertEqualMaps(t, expect, result)
 }
 
+func TestUnicodeNormalization(t *testing.T) {
+	// Precomposed U+00E9 and decomposed e + U+0301.
+	result := WordCount("caf\u00e9 cafe\u0301 CAFE\u0301.")
+	assertEqualMaps(t, map[string]int{"caf\u00e9": 3}, result)
+}
+
+func TestEdgePunctuation(t *testing.T) {
+	result := WordCount("'quoted' -dash- can't--stop rock'n'roll 2-for-1")
+	expect := map[string]int{
+		"quoted":      1,
+		"dash":        1,
+		"can't":       1,
+		"stop":        1,
+		"rock'n'roll": 1,
+		"2-for-1":     1,
+	}
+	assertEqualMaps(t, expect, result)
+}
+
+func TestWordStats(t *testing.T) {
+	freq, total, distinct := WordStats("state-of-the-art equipment! It's state-of-the-art.")
+	if total != 4 || distinct != 3 {
+		t.Fatalf("expected total=4 distinct=3, got total=%d distinct=%d", total, distinct)
+	}
+	assertEqualMaps(t, WordCount("state-of-the-art equipment! It's state-of-the-art."), freq)
+
+	freq, total, distinct = WordStats("")
+	if total != 0 || distinct != 0 || freq == nil || len(freq) != 0 {
+		t.Fatalf("expected zeros and an empty map, got %#v %d %d", freq, total, distinct)
+	}
+}
+
+func TestCountAll(t *testing.T) {
+	const fixture = "The café's state-of-the-art\nrock--n'roll 'quoted'\n\nlast line"
+	cases := []struct {
+		input               string
+		words, lines, chars int
+	}{
+		{fixture, 8, 4, 60},
+		{fixture + "\n", 8, 4, 61},
+		{"", 0, 0, 0},
+		{"\n", 0, 1, 1},
+		{"one", 1, 1, 3},
+		{"a\n\n", 1, 2, 3},
+	}
+	for _, c := range cases {
+		words, lines, chars := CountAll(c.input)
+		if words != c.words || lines != c.lines || chars != c.chars {
+			t.Errorf("CountAll(%q) = %d, %d, %d; want %d, %d, %d", c.input, words, lines, chars, c.words, c.lines, c.chars)
+		}
+		if _, total, _ := WordStats(c.input); words != total {
+			t.Errorf("CountAll(%q) counted %d words; WordStats %d", c.input, words, total)
+		}
+	}
+}
+
+func TestWordPercentages(t *testing.T) {
+	result := WordPercentages("the cat and the hat, the end")
+	if len(result) != 5 || result["the"] != 3.0/7 || result["cat"] != 1.0/7 {
+		t.Fatalf("unexpected shares %#v", result)
+	}
+	sum := 0.0
+	for _, share := range result {
+		sum += share
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Fatalf("expected shares to sum to 1, got %v", sum)
+	}
+	if result := WordPercentages("  ,. "); len(result) != 0 {
+		t.Fatalf("expected empty map, got %#v", result)
+	}
+}
+
+func TestTopWords(t *testing.T) {
+	result := TopWords("b a c b a d c b", 3)
+	// a and c tie on two; a sorts first.
+	expect := []WordFreq{{"b", 3}, {"a", 2}, {"c", 2}}
+	assertEqualFreqs(t, expect, result)
+
+	assertEqualFreqs(t, []WordFreq{{"x", 1}}, TopWords("X", 5))
+	if result := TopWords("a b", 0); result != nil {
+		t.Fatalf("expected nil for n=0, got %#v", result)
+	}
+}
+
+func TestWordCountFiltered(t *testing.T) {
+	result := WordCountFiltered("The end of the road, and THE start.", DefaultStopWords())
+	expect := map[string]int{"end": 1, "road": 1, "start": 1}
+	assertEqualMaps(t, expect, result)
+
+	result = WordCountFiltered("Foo bar foo.", map[string]struct{}{"FOO": {}})
+	assertEqualMaps(t, map[string]int{"bar": 1}, result)
+}
+
+func TestWordCountReaderMatchesWordCount(t *testing.T) {
+	var b strings.Builder
+	line := "State-of-the-art café, can't stop; naïve--words 'quoted' x-\n"
+	for b.Len() < 4<<20 {
+		b.WriteString(line)
+	}
+	input := b.String()
+
+	result, err := WordCountReader(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqualMaps(t, WordCount(input), result)
+
+	// One byte per read splits every word and multi-byte rune.
+	input = strings.Repeat(line, 10)
+	result, err = WordCountReader(iotest.OneByteReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqualMaps(t, WordCount(input), result)
+}
+
+func TestWordCountReaderError(t *testing.T) {
+	boom := errors.New("boom")
+	_, err := WordCountReader(iotest.ErrReader(boom))
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected read error, got %v", err)
+	}
+}
+
+func TestWordCountWithOptions(t *testing.T) {
+	const input = "The cat can't see the state-of-the-art Cat."
+	defaults := DefaultOptions()
+	assertEqualMaps(t, WordCount(input), WordCountWith(input, defaults))
+
+	opts := defaults
+	opts.CaseSensitive = true
+	assertEqualMaps(t, map[string]int{
+		"The": 1, "the": 1, "cat": 1, "Cat": 1, "can't": 1, "see": 1, "state-of-the-art": 1,
+	}, WordCountWith(input, opts))
+
+	opts = defaults
+	opts.KeepHyphens = false
+	assertEqualMaps(t, map[string]int{
+		"the": 3, "cat": 2, "can't": 1, "see": 1, "state": 1, "of": 1, "art": 1,
+	}, WordCountWith(input, opts))
+
+	opts = defaults
+	opts.KeepApostrophes = false
+	assertEqualMaps(t, map[string]int{
+		"the": 2, "cat": 2, "cant": 1, "see": 1, "state-of-the-art": 1,
+	}, WordCountWith(input, opts))
+
+	opts = defaults
+	opts.IgnoreNumbers = true
+	assertEqualMaps(t, map[string]int{"covid19": 1, "v2": 1, "in": 1},
+		WordCountWith("covid19 in 2023, v2 3.14 2023-2024", opts))
+
+	opts = defaults
+	opts.MinLength = 4
+	assertEqualMaps(t, map[string]int{
+		"can't": 1, "state-of-the-art": 1,
+	}, WordCountWith(input, opts))
+}
+
+func TestNGramCount(t *testing.T) {
+	assertEqualMaps(t, map[string]int{"hello hello": 1, "hello world": 1},
+		NGramCount("hello hello world", 2))
+	assertEqualMaps(t, map[string]int{"a b c": 2, "b c a": 1, "c a b": 1},
+		NGramCount("A b, c. A b c", 3))
+	assertEqualMaps(t, map[string]int{}, NGramCount("only", 2))
+	assertEqualMaps(t, map[string]int{}, NGramCount("a b", 0))
+}
+
+func TestWordCountAllMatchesSequential(t *testing.T) {
+	inputs := []string{
+		"Hello hello world",
+		"state-of-the-art equipment! It's state-of-the-art.",
+		"",
+		"naïve café naïve",
+	}
+	for i := 0; i < 50; i++ {
+		inputs = append(inputs, strings.Repeat("word ", i)+"Hello")
+	}
+	assertEqualMaps(t, WordCount(strings.Join(inputs, " ")), WordCountAll(inputs))
+	assertEqualMaps(t, map[string]int{}, WordCountAll(nil))
+}
+
+func TestSortedWordCount(t *testing.T) {
+	result := SortedWordCount("d b a c b a e d f b e")
+	expect := []WordFreq{
+		{"b", 3},
+		{"a", 2}, {"d", 2}, {"e", 2},
+		{"c", 1}, {"f", 1},
+	}
+	assertEqualFreqs(t, expect, result)
+	assertEqualFreqs(t, nil, SortedWordCount(""))
+}
+
+func TestWordCountFunc(t *testing.T) {
+	american := map[string]string{"colour": "color", "favourite": "favorite"}
+	normalize := func(word string) string {
+		word = strings.ToLower(word)
+		if word == "a" {
+			return ""
+		}
+		if us, ok := american[word]; ok {
+			return us
+		}
+		return word
+	}
+	result := WordCountFunc("A Colour, a color; my favourite colour-scheme.", normalize)
+	expect := map[string]int{
+		"color":         2,
+		"my":            1,
+		"favorite":      1,
+		"colour-scheme": 1,
+	}
+	assertEqualMaps(t, expect, result)
+}
+
+func TestMinLengthCountsRunes(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MinLength = 3
+	result := WordCountWith("It's a café, né? ok yes", opts)
+	// "né" is three bytes but two runes.
+	assertEqualMaps(t, map[string]int{"it's": 1, "café": 1, "yes": 1}, result)
+}
+
 func assertEqualMaps(t *testing.T, expect, actual map[string]int) {
 	t.Helper()
 	if len(expect) != len(actual) {
@@ -56,3 +286,15 @@ func assertEqualMaps(t *testing.T, expect, actual map[string]int) {
 		}
 	}
 }
+
+func assertEqualFreqs(t *testing.T, expect, actual []WordFreq) {
+	t.Helper()
+	if len(expect) != len(actual) {
+		t.Fatalf("length mismatch: expect=%v actual=%v", expect, actual)
+	}
+	for i := range expect {
+		if expect[i] != actual[i] {
+			t.Fatalf("mismatch at %d: expect=%v actual=%v", i, expect, actual)
+		}
+	}
+}